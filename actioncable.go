@@ -0,0 +1,197 @@
+package apic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// ActionCableConfig configures an ActionCableClient.
+type ActionCableConfig struct {
+	// OnReject, if set, is called when the server rejects a subscription.
+	// Returning an error terminates the connection.
+	OnReject func(identifier string) error
+}
+
+// ActionCableHandler receives each broadcast on a subscribed channel.
+type ActionCableHandler func(message json.RawMessage) error
+
+// ActionCableDisconnectError is returned when the server sends a disconnect message.
+type ActionCableDisconnectError struct {
+	Reason    string
+	Reconnect bool
+}
+
+func (e *ActionCableDisconnectError) Error() string {
+	return fmt.Sprintf("action cable: disconnected by server: %s (reconnect: %t)", e.Reason, e.Reconnect)
+}
+
+// ActionCableClient speaks the Rails Action Cable protocol over WSClient.
+// Subscriptions are (re)sent after each welcome message, so they survive
+// reconnects.
+type ActionCableClient struct {
+	ws  *WSClient
+	cfg ActionCableConfig
+
+	mu        sync.Mutex
+	welcomed  bool
+	subs      map[string]ActionCableHandler
+	confirmed map[string]bool
+}
+
+type actionCableMessage struct {
+	Type       string          `json:"type"`
+	Identifier string          `json:"identifier"`
+	Message    json.RawMessage `json:"message"`
+	Reason     string          `json:"reason"`
+	Reconnect  bool            `json:"reconnect"`
+}
+
+type actionCableCommand struct {
+	Command    string `json:"command"`
+	Identifier string `json:"identifier"`
+	Data       string `json:"data,omitempty"`
+}
+
+// NewActionCableClient creates an Action Cable client for the endpoint. The options
+// are passed along to the underlying WSClient, except for the global message handler,
+// which the Action Cable client owns.
+func NewActionCableClient(endpoint string, cfg ActionCableConfig, opts ...WSOption) *ActionCableClient {
+	c := &ActionCableClient{
+		cfg:       cfg,
+		subs:      map[string]ActionCableHandler{},
+		confirmed: map[string]bool{},
+	}
+	opts = append(append([]WSOption{}, opts...), WithWSHandler(c.handle), WithWSEncoder(defaultEncoder))
+	c.ws = NewWSClient(endpoint, opts...)
+	c.ws.addSubprotocols("actioncable-v1-json")
+	c.ws.chainOnClose(c.onClose)
+	return c
+}
+
+// WS returns the underlying websocket client.
+func (c *ActionCableClient) WS() *WSClient {
+	return c.ws
+}
+
+// Start runs the underlying websocket client. See WSClient.Start.
+func (c *ActionCableClient) Start(ctx context.Context) error {
+	return c.ws.Start(ctx)
+}
+
+// Subscribe subscribes to the channel identified by params, which must include
+// the "channel" key (ie, {"channel": "ChatChannel", "room": "1"}). The returned
+// identifier is used to perform actions on, and unsubscribe from, the channel.
+func (c *ActionCableClient) Subscribe(ctx context.Context, params map[string]any, fn ActionCableHandler) (string, error) {
+	bts, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	identifier := string(bts)
+
+	c.mu.Lock()
+	c.subs[identifier] = fn
+	welcomed := c.welcomed
+	c.mu.Unlock()
+
+	if !welcomed {
+		return identifier, nil
+	}
+	return identifier, c.ws.Write(ctx, actionCableCommand{Command: "subscribe", Identifier: identifier})
+}
+
+// Unsubscribe unsubscribes from the channel.
+func (c *ActionCableClient) Unsubscribe(ctx context.Context, identifier string) error {
+	c.mu.Lock()
+	_, ok := c.subs[identifier]
+	delete(c.subs, identifier)
+	delete(c.confirmed, identifier)
+	welcomed := c.welcomed
+	c.mu.Unlock()
+
+	if !ok || !welcomed {
+		return nil
+	}
+	return c.ws.Write(ctx, actionCableCommand{Command: "unsubscribe", Identifier: identifier})
+}
+
+// Perform calls action on the subscribed channel's server side, with data.
+func (c *ActionCableClient) Perform(ctx context.Context, identifier, action string, data map[string]any) error {
+	payload := map[string]any{}
+	for k, v := range data {
+		payload[k] = v
+	}
+	payload["action"] = action
+	bts, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return c.ws.Write(ctx, actionCableCommand{Command: "message", Identifier: identifier, Data: string(bts)})
+}
+
+// Confirmed reports whether the server has confirmed the subscription on
+// the current connection.
+func (c *ActionCableClient) Confirmed(identifier string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.confirmed[identifier]
+}
+
+func (c *ActionCableClient) onClose(_ *WSClient) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.welcomed = false
+	c.confirmed = map[string]bool{}
+	return nil
+}
+
+func (c *ActionCableClient) handle(bts []byte) error {
+	var msg actionCableMessage
+	if err := json.Unmarshal(bts, &msg); err != nil {
+		return err
+	}
+
+	switch msg.Type {
+	case "welcome":
+		c.mu.Lock()
+		c.welcomed = true
+		identifiers := make([]string, 0, len(c.subs))
+		for identifier := range c.subs {
+			identifiers = append(identifiers, identifier)
+		}
+		c.mu.Unlock()
+		for _, identifier := range identifiers {
+			if err := c.ws.Write(context.Background(), actionCableCommand{Command: "subscribe", Identifier: identifier}); err != nil {
+				return err
+			}
+		}
+	case "ping":
+	case "confirm_subscription":
+		c.mu.Lock()
+		c.confirmed[msg.Identifier] = true
+		c.mu.Unlock()
+	case "reject_subscription":
+		c.ws.logger.Info("action cable: subscription rejected", "identifier", msg.Identifier)
+		c.mu.Lock()
+		delete(c.confirmed, msg.Identifier)
+		c.mu.Unlock()
+		if c.cfg.OnReject != nil {
+			return c.cfg.OnReject(msg.Identifier)
+		}
+	case "disconnect":
+		return &ActionCableDisconnectError{Reason: msg.Reason, Reconnect: msg.Reconnect}
+	case "":
+		c.mu.Lock()
+		fn, ok := c.subs[msg.Identifier]
+		c.mu.Unlock()
+		if !ok {
+			c.ws.logger.Debug("action cable: message for unknown subscription", "identifier", msg.Identifier)
+			return nil
+		}
+		return fn(msg.Message)
+	default:
+		c.ws.logger.Debug("action cable: unknown message type", "type", msg.Type)
+	}
+	return nil
+}
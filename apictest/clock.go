@@ -0,0 +1,129 @@
+package apictest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rileyr/apic"
+)
+
+// Clock is a fake apic.Clock, for use with apic.WithClock. Time only moves
+// when Advance is called, firing any timers and tickers that come due.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeTimer
+}
+
+var _ apic.Clock = (*Clock)(nil)
+
+// NewClock returns a fake clock set to now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Clock) NewTimer(d time.Duration) apic.Timer {
+	return c.add(d, 0)
+}
+
+func (c *Clock) NewTicker(d time.Duration) apic.Ticker {
+	return fakeTicker{c.add(d, d)}
+}
+
+// Advance moves the clock forward by d, firing due timers and tickers in order.
+// Like their time package counterparts, a ticker that isn't keeping up drops ticks.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end := c.now.Add(d)
+	for {
+		next := c.nextDue(end)
+		if next == nil {
+			break
+		}
+		c.now = next.at
+		select {
+		case next.ch <- c.now:
+		default:
+		}
+		if next.period > 0 {
+			next.at = next.at.Add(next.period)
+		} else {
+			c.remove(next)
+		}
+	}
+	c.now = end
+}
+
+// Waiters returns the number of pending timers and tickers, so tests can wait
+// for the client to arm one before advancing.
+func (c *Clock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+func (c *Clock) add(d, period time.Duration) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), period: period, ch: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, t)
+	return t
+}
+
+// nextDue returns the earliest timer due by end. mu must be held.
+func (c *Clock) nextDue(end time.Time) *fakeTimer {
+	var next *fakeTimer
+	for _, t := range c.waiters {
+		if t.at.After(end) {
+			continue
+		}
+		if next == nil || t.at.Before(next.at) {
+			next = t
+		}
+	}
+	return next
+}
+
+// remove stops tracking t, reporting whether it was pending. mu must be held.
+func (c *Clock) remove(t *fakeTimer) bool {
+	for i, w := range c.waiters {
+		if w == t {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock  *Clock
+	at     time.Time
+	period time.Duration
+	ch     chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
+
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
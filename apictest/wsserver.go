@@ -0,0 +1,255 @@
+// Package apictest provides test helpers for code using apic.
+package apictest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// Step is a single step of a Script.
+type Step struct {
+	// reject, if set, rejects the handshake with this http status
+	reject int
+
+	run func(ctx context.Context, s *session) error
+}
+
+// Script is the steps the server runs for a single connection. Once the steps
+// are done, the connection is held open until the client closes it.
+type Script []Step
+
+// Send sends a text frame.
+func Send(msg string) Step {
+	return Step{run: func(ctx context.Context, s *session) error {
+		return s.conn.Write(ctx, websocket.MessageText, []byte(msg))
+	}}
+}
+
+// SendBinary sends a binary frame.
+func SendBinary(msg []byte) Step {
+	return Step{run: func(ctx context.Context, s *session) error {
+		return s.conn.Write(ctx, websocket.MessageBinary, msg)
+	}}
+}
+
+// Delay pauses for d.
+func Delay(d time.Duration) Step {
+	return Step{run: func(ctx context.Context, _ *session) error {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}}
+}
+
+// Expect waits for the client to send a frame containing substr.
+func Expect(substr string) Step {
+	return ExpectFunc(func(msg []byte) bool {
+		return bytes.Contains(msg, []byte(substr))
+	})
+}
+
+// ExpectFunc waits for the client to send a frame matching match. Frames that
+// don't match are skipped.
+func ExpectFunc(match func([]byte) bool) Step {
+	return Step{run: func(ctx context.Context, s *session) error {
+		for {
+			msg, err := s.next(ctx)
+			if err != nil {
+				return err
+			}
+			if match(msg) {
+				return nil
+			}
+		}
+	}}
+}
+
+// Close closes the connection with the given code and reason.
+func Close(code websocket.StatusCode, reason string) Step {
+	return Step{run: func(_ context.Context, s *session) error {
+		s.conn.Close(code, reason)
+		return errDone
+	}}
+}
+
+// Drop drops the connection without a close frame.
+func Drop() Step {
+	return Step{run: func(_ context.Context, s *session) error {
+		s.conn.CloseNow()
+		return errDone
+	}}
+}
+
+// Reject rejects the handshake with the given http status, ie,
+// http.StatusUnauthorized. It must be the first step of its script.
+func Reject(status int) Step {
+	return Step{reject: status}
+}
+
+// errDone ends a script early.
+var errDone = errors.New("script done")
+
+// WSServer is a websocket test server running scripted scenarios. The nth
+// connection runs the nth script, with the last script repeating.
+type WSServer struct {
+	// URL is the ws:// url of the server.
+	URL string
+
+	srv     *httptest.Server
+	scripts []Script
+
+	mu       sync.Mutex
+	conns    int
+	received [][]byte
+}
+
+// NewWSServer starts a server running the given scripts. Close it when done.
+func NewWSServer(scripts ...Script) *WSServer {
+	s := &WSServer{scripts: scripts}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = "ws" + strings.TrimPrefix(s.srv.URL, "http")
+	return s
+}
+
+// Close shuts the server down.
+func (s *WSServer) Close() {
+	s.srv.CloseClientConnections()
+	s.srv.Close()
+}
+
+// Connections returns the number of connections made, including rejected handshakes.
+func (s *WSServer) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+// Received returns every frame received from clients, in order.
+func (s *WSServer) Received() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte{}, s.received...)
+}
+
+type session struct {
+	conn *websocket.Conn
+
+	// inbound holds the frames no step has taken yet, however many, with
+	// ready signalled as frames arrive or the connection closes
+	mu      sync.Mutex
+	inbound [][]byte
+	closed  bool
+	ready   chan struct{}
+}
+
+func (s *session) push(msg []byte) {
+	s.mu.Lock()
+	s.inbound = append(s.inbound, msg)
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *session) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *session) signal() {
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the oldest frame not yet taken, waiting for one if need be.
+func (s *session) next(ctx context.Context) ([]byte, error) {
+	for {
+		s.mu.Lock()
+		if len(s.inbound) != 0 {
+			msg := s.inbound[0]
+			s.inbound = s.inbound[1:]
+			s.mu.Unlock()
+			return msg, nil
+		}
+		closed := s.closed
+		s.mu.Unlock()
+		if closed {
+			return nil, errDone
+		}
+
+		select {
+		case <-s.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *WSServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	n := s.conns
+	s.conns++
+	s.mu.Unlock()
+
+	var script Script
+	if len(s.scripts) != 0 {
+		script = s.scripts[min(n, len(s.scripts)-1)]
+	}
+	if len(script) != 0 && script[0].reject != 0 {
+		http.Error(w, http.StatusText(script[0].reject), script[0].reject)
+		return
+	}
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	sess := &session{conn: conn, ready: make(chan struct{}, 1)}
+	go s.read(ctx, cancel, sess)
+
+	for _, step := range script {
+		if step.run == nil {
+			continue
+		}
+		if err := step.run(ctx, sess); err != nil {
+			return
+		}
+	}
+	<-ctx.Done()
+}
+
+// read records frames from the client, until the connection is closed.
+func (s *WSServer) read(ctx context.Context, cancel func(), sess *session) {
+	defer cancel()
+	defer sess.close()
+	for {
+		_, msg, err := sess.conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.received = append(s.received, msg)
+		s.mu.Unlock()
+		sess.push(msg)
+	}
+}
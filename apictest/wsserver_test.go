@@ -0,0 +1,120 @@
+package apictest_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rileyr/apic/apictest"
+	"nhooyr.io/websocket"
+)
+
+func dial(t *testing.T, ctx context.Context, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	return conn
+}
+
+func read(t *testing.T, ctx context.Context, conn *websocket.Conn, want string) {
+	t.Helper()
+	_, msg, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != want {
+		t.Fatalf("got %q, want %q", msg, want)
+	}
+}
+
+func TestWSServerScript(t *testing.T) {
+	srv := apictest.NewWSServer(apictest.Script{
+		apictest.Send("hello"),
+		apictest.Expect("ping"),
+		apictest.Send("pong"),
+	})
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn := dial(t, ctx, srv.URL)
+
+	read(t, ctx, conn, "hello")
+	if err := conn.Write(ctx, websocket.MessageText, []byte("a ping")); err != nil {
+		t.Fatal(err)
+	}
+	read(t, ctx, conn, "pong")
+
+	if n := srv.Connections(); n != 1 {
+		t.Errorf("got %d connections, want 1", n)
+	}
+	if got := srv.Received(); len(got) != 1 || string(got[0]) != "a ping" {
+		t.Errorf("got received %q", got)
+	}
+}
+
+func TestWSServerKeepsEveryFrame(t *testing.T) {
+	const frames = 500
+
+	// the frames all arrive before any step takes them
+	script := apictest.Script{apictest.Delay(200 * time.Millisecond)}
+	for i := 0; i < frames; i++ {
+		script = append(script, apictest.Expect(fmt.Sprintf("m%03d", i)))
+	}
+	script = append(script, apictest.Send("done"))
+	srv := apictest.NewWSServer(script)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn := dial(t, ctx, srv.URL)
+
+	for i := 0; i < frames; i++ {
+		if err := conn.Write(ctx, websocket.MessageText, []byte(fmt.Sprintf("m%03d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read(t, ctx, conn, "done")
+}
+
+func TestWSServerScriptPerConnection(t *testing.T) {
+	srv := apictest.NewWSServer(
+		apictest.Script{apictest.Reject(401)},
+		apictest.Script{apictest.Send("later")},
+	)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, rsp, err := websocket.Dial(ctx, srv.URL, nil); err == nil || rsp == nil || rsp.StatusCode != 401 {
+		t.Fatalf("first dial: got %v, want a 401", err)
+	}
+	// the last script repeats
+	for i := 0; i < 2; i++ {
+		read(t, ctx, dial(t, ctx, srv.URL), "later")
+	}
+	if n := srv.Connections(); n != 3 {
+		t.Errorf("got %d connections, want 3", n)
+	}
+}
+
+func TestWSServerClose(t *testing.T) {
+	srv := apictest.NewWSServer(apictest.Script{
+		apictest.Close(websocket.StatusTryAgainLater, "busy"),
+	})
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn := dial(t, ctx, srv.URL)
+
+	_, _, err := conn.Read(ctx)
+	if code := websocket.CloseStatus(err); code != websocket.StatusTryAgainLater {
+		t.Fatalf("got close status %v from %v, want %v", code, err, websocket.StatusTryAgainLater)
+	}
+}
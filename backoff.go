@@ -0,0 +1,75 @@
+package apic
+
+import (
+	"math/rand"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// Backoff is an exponential backoff with full jitter: the delay before attempt n
+// is chosen uniformly between zero and min(Max, Base * 2^(n-1)). It implements
+// ReconnectPolicy, always retrying.
+type Backoff struct {
+	// Base is the ceiling for the first attempt's delay. Defaults to 100ms.
+	Base time.Duration
+
+	// Max caps the delay ceiling. Zero means no cap.
+	Max time.Duration
+}
+
+// Delay returns the jittered delay before the given attempt, starting at 1.
+func (b Backoff) Delay(attempt int) time.Duration {
+	ceiling := b.Base
+	if ceiling <= 0 {
+		ceiling = time.Millisecond * 100
+	}
+	for i := 1; i < attempt; i++ {
+		if b.Max > 0 && ceiling >= b.Max {
+			break
+		}
+		// stop doubling before overflowing
+		if ceiling > time.Duration(1<<62) {
+			break
+		}
+		ceiling *= 2
+	}
+	if b.Max > 0 && ceiling > b.Max {
+		ceiling = b.Max
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+func (b Backoff) Reconnect(_ error, attempt int, _ time.Duration) (bool, time.Duration) {
+	return true, b.Delay(attempt)
+}
+
+// defaultCloseCodeBackoff is the backoff CloseCodePolicy uses when it has no other policy.
+var defaultCloseCodeBackoff = Backoff{Max: 30 * time.Second}
+
+// CloseCodePolicy gives up reconnecting when the server closes the connection with
+// one of the Except close codes, ie, for bad credentials or policy violations, and
+// otherwise defers to Then. A nil Then is a Backoff capped at 30 seconds.
+type CloseCodePolicy struct {
+	Except []StatusCode
+	Then   ReconnectPolicy
+}
+
+// ReconnectExcept returns a CloseCodePolicy giving up on the given close codes.
+func ReconnectExcept(codes ...StatusCode) CloseCodePolicy {
+	return CloseCodePolicy{Except: codes}
+}
+
+func (p CloseCodePolicy) Reconnect(err error, attempt int, elapsed time.Duration) (bool, time.Duration) {
+	if code := websocket.CloseStatus(err); code != -1 {
+		for _, except := range p.Except {
+			if code == except {
+				return false, 0
+			}
+		}
+	}
+	if p.Then == nil {
+		return defaultCloseCodeBackoff.Reconnect(err, attempt, elapsed)
+	}
+	return p.Then.Reconnect(err, attempt, elapsed)
+}
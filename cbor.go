@@ -0,0 +1,414 @@
+package apic
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// CBOREncoder and CBORDecoder encode and decode CBOR (RFC 8949), as MsgpackEncoder
+// and MsgpackDecoder do MessagePack: structs by their json tags, and custom
+// marshaling as in json. Decoding understands indefinite lengths, half precision
+// floats, and the date/time tags, and ignores other tags. As with MessagePack,
+// encoding NaN or an infinity fails.
+var (
+	CBOREncoder Encoder = cborMarshal
+	CBORDecoder Decoder = cborUnmarshal
+)
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// cbor major types
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+func cborMarshal(v any) ([]byte, error) {
+	var e cborEncoder
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+func cborUnmarshal(bts []byte, v any) error {
+	d := cborDecoder{buf: bts}
+	generic, err := d.decode()
+	if err != nil {
+		return err
+	}
+	if d.pos != len(d.buf) {
+		return fmt.Errorf("cbor: %d bytes of trailing data", len(d.buf)-d.pos)
+	}
+	return decodeGeneric(generic, v)
+}
+
+type cborEncoder struct {
+	buf []byte
+}
+
+// head writes a data item head: the major type, and an argument.
+func (e *cborEncoder) head(major byte, arg uint64) {
+	m := major << 5
+	switch {
+	case arg < 24:
+		e.buf = append(e.buf, m|byte(arg))
+	case arg <= math.MaxUint8:
+		e.buf = append(e.buf, m|24, byte(arg))
+	case arg <= math.MaxUint16:
+		e.buf = append(e.buf, m|25)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(arg))
+	case arg <= math.MaxUint32:
+		e.buf = append(e.buf, m|26)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(arg))
+	default:
+		e.buf = append(e.buf, m|27)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, arg)
+	}
+}
+
+func (e *cborEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf = append(e.buf, 0xf6)
+		return nil
+	}
+	if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+		e.buf = append(e.buf, 0xf6)
+		return nil
+	}
+
+	switch {
+	case v.Type().Implements(jsonMarshalerType):
+		generic, err := viaJSON(v.Interface())
+		if err != nil {
+			return err
+		}
+		return e.encode(reflect.ValueOf(generic))
+	case v.Type().Implements(textMarshalerType):
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+		e.head(cborText, uint64(len(text)))
+		e.buf = append(e.buf, text...)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, 0xf5)
+		} else {
+			e.buf = append(e.buf, 0xf4)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i := v.Int(); i < 0 {
+			e.head(cborNegint, uint64(-1-i))
+		} else {
+			e.head(cborUint, uint64(i))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.head(cborUint, v.Uint())
+	case reflect.Float32:
+		if err := finite(v.Float()); err != nil {
+			return fmt.Errorf("cbor: %w", err)
+		}
+		e.buf = append(e.buf, 0xfa)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		if err := finite(v.Float()); err != nil {
+			return fmt.Errorf("cbor: %w", err)
+		}
+		e.buf = append(e.buf, 0xfb)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.head(cborText, uint64(v.Len()))
+		e.buf = append(e.buf, v.String()...)
+	case reflect.Slice:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xf6)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.head(cborBytes, uint64(v.Len()))
+			e.buf = append(e.buf, v.Bytes()...)
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xf6)
+			return nil
+		}
+		e.head(cborMap, uint64(v.Len()))
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := e.encode(iter.Key()); err != nil {
+				return err
+			}
+			if err := e.encode(iter.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields := jsonFields(v)
+		e.head(cborMap, uint64(len(fields)))
+		for _, f := range fields {
+			e.head(cborText, uint64(len(f.name)))
+			e.buf = append(e.buf, f.name...)
+			if err := e.encode(f.value); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cbor: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func (e *cborEncoder) encodeArray(v reflect.Value) error {
+	e.head(cborArray, uint64(v.Len()))
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type cborDecoder struct {
+	buf   []byte
+	pos   int
+	depth int
+}
+
+// errCBORBreak is returned by decode on the break that ends an indefinite length
+// item.
+var errCBORBreak = errors.New("cbor: unexpected break")
+
+func (d *cborDecoder) next(n uint64) ([]byte, error) {
+	if uint64(len(d.buf)-d.pos) < n {
+		return nil, errCBORTruncated
+	}
+	b := d.buf[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// head reads a data item head, returning its major type, additional info, and
+// argument. indefinite reports an indefinite length.
+func (d *cborDecoder) head() (major, info byte, arg uint64, indefinite bool, err error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	major, info = b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info), false, nil
+	case info == 31:
+		return major, info, 0, true, nil
+	case info > 27:
+		return 0, 0, 0, false, fmt.Errorf("cbor: reserved additional info %d", info)
+	}
+	ab, err := d.next(1 << (info - 24))
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	switch len(ab) {
+	case 1:
+		arg = uint64(ab[0])
+	case 2:
+		arg = uint64(binary.BigEndian.Uint16(ab))
+	case 4:
+		arg = uint64(binary.BigEndian.Uint32(ab))
+	default:
+		arg = binary.BigEndian.Uint64(ab)
+	}
+	return major, info, arg, false, nil
+}
+
+// decode decodes the next data item as nil, a bool, int64, uint64, float64,
+// string, []byte, time.Time, []any, or map[string]any, with non-string map keys
+// formatted as strings.
+func (d *cborDecoder) decode() (any, error) {
+	if d.depth++; d.depth > maxDecodeDepth {
+		return nil, errors.New("cbor: exceeded max depth")
+	}
+	defer func() { d.depth-- }()
+
+	major, info, arg, indefinite, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUint:
+		if arg <= math.MaxInt64 {
+			return int64(arg), nil
+		}
+		return arg, nil
+	case cborNegint:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("cbor: negative integer overflows int64")
+		}
+		return -1 - int64(arg), nil
+	case cborBytes, cborText:
+		bts, err := d.decodeString(major, arg, indefinite)
+		if err != nil {
+			return nil, err
+		}
+		if major == cborText {
+			return string(bts), nil
+		}
+		return bts, nil
+	case cborArray:
+		arr := []any{}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			v, err := d.decode()
+			if indefinite && err == errCBORBreak {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	case cborMap:
+		m := map[string]any{}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			k, err := d.decode()
+			if indefinite && err == errCBORBreak {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			if s, ok := k.(string); ok {
+				m[s] = v
+			} else {
+				m[fmt.Sprint(k)] = v
+			}
+		}
+		return m, nil
+	case cborTag:
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		return cborTagged(arg, v)
+	}
+
+	// major type 7: simple values and floats
+	switch {
+	case indefinite:
+		return nil, errCBORBreak
+	case info == 20:
+		return false, nil
+	case info == 21:
+		return true, nil
+	case info == 22, info == 23:
+		return nil, nil
+	case info == 25:
+		return halfFloat(uint16(arg)), nil
+	case info == 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case info == 27:
+		return math.Float64frombits(arg), nil
+	}
+	return nil, fmt.Errorf("cbor: unsupported simple value %d", arg)
+}
+
+// decodeString decodes a byte or text string, concatenating the chunks of an
+// indefinite length one.
+func (d *cborDecoder) decodeString(major byte, n uint64, indefinite bool) ([]byte, error) {
+	if !indefinite {
+		bts, err := d.next(n)
+		return append([]byte(nil), bts...), err
+	}
+	var bts []byte
+	for {
+		m, _, arg, ind, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		if m == cborSimple && ind {
+			return bts, nil
+		}
+		if m != major || ind {
+			return nil, errors.New("cbor: bad indefinite length string chunk")
+		}
+		chunk, err := d.next(arg)
+		if err != nil {
+			return nil, err
+		}
+		bts = append(bts, chunk...)
+	}
+}
+
+// cborTagged interprets the date/time tags, passing other tagged values through.
+func cborTagged(tag uint64, v any) (any, error) {
+	switch tag {
+	case 0:
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.New("cbor: date/time tag on a non-string")
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	case 1:
+		switch v := v.(type) {
+		case int64:
+			return time.Unix(v, 0).UTC(), nil
+		case uint64:
+			return time.Unix(int64(v), 0).UTC(), nil
+		case float64:
+			sec, frac := math.Modf(v)
+			return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+		}
+		return nil, errors.New("cbor: epoch time tag on a non-number")
+	}
+	return v, nil
+}
+
+// halfFloat converts an IEEE 754 half precision float.
+func halfFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
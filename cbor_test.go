@@ -0,0 +1,163 @@
+package apic
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// cbor vectors from RFC 8949, appendix A
+func TestCBORDecode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want any
+	}{
+		{"00", int64(0)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"1903e8", int64(1000)},
+		{"1bffffffffffffffff", uint64(math.MaxUint64)},
+		{"20", int64(-1)},
+		{"3903e7", int64(-1000)},
+		{"f90001", 5.960464477539063e-8},
+		{"f93e00", 1.5},
+		{"f9c400", -4.0},
+		{"fa47c35000", 100000.0},
+		{"fb3ff199999999999a", 1.1},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"f7", nil},
+		{"c074323031332d30332d32315432303a30343a30305a", time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)},
+		{"c11a514b67b0", time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)},
+		{"d74401020304", []byte{1, 2, 3, 4}},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"6449455446", "IETF"},
+		{"62c3bc", "ü"},
+		{"83010203", []any{int64(1), int64(2), int64(3)}},
+		{"a201020304", map[string]any{"1": int64(2), "3": int64(4)}},
+		{"5f42010243030405ff", []byte{1, 2, 3, 4, 5}},
+		{"7f657374726561646d696e67ff", "streaming"},
+		{"9f018202039f0405ffff", []any{int64(1), []any{int64(2), int64(3)}, []any{int64(4), int64(5)}}},
+		{"bf61610161629f0203ffff", map[string]any{"a": int64(1), "b": []any{int64(2), int64(3)}}},
+	}
+	for _, tt := range tests {
+		in, _ := hex.DecodeString(tt.in)
+		var got any
+		if err := CBORDecoder(in, &got); err != nil {
+			t.Errorf("decode %s: %v", tt.in, err)
+			continue
+		}
+		if tm, ok := got.(time.Time); ok {
+			got = tm.UTC()
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("decode %s: got %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCBOREncode(t *testing.T) {
+	tests := []struct {
+		in   any
+		want string
+	}{
+		{0, "00"},
+		{24, "1818"},
+		{-1000, "3903e7"},
+		{uint64(math.MaxUint64), "1bffffffffffffffff"},
+		{1.1, "fb3ff199999999999a"},
+		{"IETF", "6449455446"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{[]int{1, 2, 3}, "83010203"},
+		{nil, "f6"},
+	}
+	for _, tt := range tests {
+		got, err := CBOREncoder(tt.in)
+		if err != nil {
+			t.Errorf("encode %v: %v", tt.in, err)
+			continue
+		}
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("encode %v: got %x, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCBORRoundTrip(t *testing.T) {
+	type inner struct {
+		N int `json:"n"`
+	}
+	type value struct {
+		Int     int64             `json:"int"`
+		Uint    uint64            `json:"uint"`
+		Float   float32           `json:"float"`
+		Str     string            `json:"str"`
+		Bytes   []byte            `json:"bytes"`
+		Slice   []inner           `json:"slice"`
+		Map     map[string]string `json:"map"`
+		Ptr     *inner            `json:"ptr"`
+		Omitted string            `json:"omitted,omitempty"`
+		Time    time.Time         `json:"time"`
+	}
+	in := value{
+		Int:   math.MinInt64,
+		Uint:  math.MaxUint64,
+		Float: 0.5,
+		Str:   string(bytes.Repeat([]byte("y"), 300)),
+		Bytes: []byte{0, 1, 2},
+		Slice: []inner{{1}, {2}},
+		Map:   map[string]string{"a": "b"},
+		Ptr:   &inner{3},
+		Time:  time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+	}
+	bts, err := CBOREncoder(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out value
+	if err := CBORDecoder(bts, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("got %+v, want %+v", out, in)
+	}
+}
+
+func TestCBORMalformed(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":             {},
+		"truncated string":  {0x64, 'a'},
+		"truncated array":   {0x82, 0x01},
+		"truncated head":    {0x19, 0x01},
+		"huge string":       {0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"huge array":        {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"reserved info":     {0x1c},
+		"stray break":       {0xff},
+		"unterminated":      {0x9f, 0x01},
+		"bad string chunk":  {0x5f, 0x61, 'a', 0xff},
+		"negint overflow":   {0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"trailing data":     {0x01, 0x02},
+		"bad date tag":      {0xc0, 0x01},
+		"too deep arrays":   bytes.Repeat([]byte{0x81}, 50000),
+		"too deep tags":     bytes.Repeat([]byte{0xc6}, 50000),
+		"unsupported value": {0xf8, 0x20},
+	}
+	for name, in := range tests {
+		var v any
+		if err := CBORDecoder(in, &v); err == nil {
+			t.Errorf("%s: decoded % .16x without error", name, in)
+		}
+	}
+}
+
+func TestCBORNonFinite(t *testing.T) {
+	for _, f := range []any{math.NaN(), math.Inf(1), float32(math.Inf(-1))} {
+		if _, err := CBOREncoder(f); err == nil {
+			t.Errorf("encoded %v without error", f)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+package apic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CentrifugeConfig configures a CentrifugeClient.
+type CentrifugeConfig struct {
+	// Token is the connection JWT. If TokenFunc is set, it is used instead, and is
+	// called ahead of each connect so reconnects use a fresh token.
+	Token     string
+	TokenFunc func() (string, error)
+
+	// Data is optional custom connect data.
+	Data any
+
+	// Ping is the interval at which the client pings the server. Defaults to 25 seconds.
+	Ping time.Duration
+}
+
+// CentrifugeEventType is the kind of a CentrifugeEvent.
+type CentrifugeEventType int
+
+const (
+	CentrifugePublication CentrifugeEventType = iota
+	CentrifugeJoin
+	CentrifugeLeave
+)
+
+// CentrifugeClientInfo describes a client connected to a channel.
+type CentrifugeClientInfo struct {
+	User     string          `json:"user"`
+	Client   string          `json:"client"`
+	ConnInfo json.RawMessage `json:"conn_info,omitempty"`
+	ChanInfo json.RawMessage `json:"chan_info,omitempty"`
+}
+
+// CentrifugeEvent is a publication, join, or leave on a subscribed channel.
+// For publications, Data is the publication data and Offset its position in
+// the channel's stream; Info is the publisher, if known.
+type CentrifugeEvent struct {
+	Type    CentrifugeEventType
+	Channel string
+	Data    json.RawMessage
+	Offset  uint64
+	Info    *CentrifugeClientInfo
+}
+
+// CentrifugeHandler receives each event on a subscribed channel.
+type CentrifugeHandler func(CentrifugeEvent) error
+
+// CentrifugeError is an error returned by the server in reply to a command.
+type CentrifugeError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *CentrifugeError) Error() string {
+	return fmt.Sprintf("centrifuge error %d: %s", e.Code, e.Message)
+}
+
+const (
+	centrifugeConnect     = 0
+	centrifugeSubscribe   = 1
+	centrifugeUnsubscribe = 2
+	centrifugePublish     = 3
+	centrifugePing        = 7
+	centrifugeRPC         = 9
+)
+
+const (
+	centrifugePushPublication = 0
+	centrifugePushJoin        = 1
+	centrifugePushLeave       = 2
+	centrifugePushUnsub       = 3
+)
+
+// CentrifugeClient speaks the Centrifuge/Centrifugo v2 json protocol over WSClient.
+// Subscriptions are re-sent after each reconnect, recovering missed publications
+// from the last seen stream position where the channel supports it.
+type CentrifugeClient struct {
+	ws  *WSClient
+	cfg CentrifugeConfig
+
+	mu        sync.Mutex
+	seq       uint32
+	connectID uint32
+	connected bool
+	subs      map[string]*centrifugeSub
+	pending   map[uint32]string
+	stop      chan struct{}
+}
+
+type centrifugeSub struct {
+	fn          CentrifugeHandler
+	recoverable bool
+	offset      uint64
+	epoch       string
+}
+
+type centrifugeCommand struct {
+	ID     uint32 `json:"id"`
+	Method int    `json:"method,omitempty"`
+	Params any    `json:"params,omitempty"`
+}
+
+type centrifugeReply struct {
+	ID     uint32           `json:"id"`
+	Error  *CentrifugeError `json:"error"`
+	Result json.RawMessage  `json:"result"`
+}
+
+type centrifugePublication struct {
+	Data   json.RawMessage       `json:"data"`
+	Info   *CentrifugeClientInfo `json:"info"`
+	Offset uint64                `json:"offset"`
+}
+
+// NewCentrifugeClient creates a Centrifuge client for the endpoint (ie,
+// wss://host/connection/websocket). The options are passed along to the underlying
+// WSClient, except for the global message handler, which the Centrifuge client owns.
+func NewCentrifugeClient(endpoint string, cfg CentrifugeConfig, opts ...WSOption) *CentrifugeClient {
+	if cfg.Ping == 0 {
+		cfg.Ping = time.Second * 25
+	}
+	c := &CentrifugeClient{
+		cfg:     cfg,
+		subs:    map[string]*centrifugeSub{},
+		pending: map[uint32]string{},
+	}
+	opts = append(append([]WSOption{}, opts...), WithWSHandler(c.handle), WithWSEncoder(defaultEncoder))
+	c.ws = NewWSClient(endpoint, opts...)
+	c.ws.chainOnOpen(c.onOpen)
+	c.ws.chainOnClose(c.onClose)
+	return c
+}
+
+// WS returns the underlying websocket client.
+func (c *CentrifugeClient) WS() *WSClient {
+	return c.ws
+}
+
+// Start runs the underlying websocket client. See WSClient.Start.
+func (c *CentrifugeClient) Start(ctx context.Context) error {
+	return c.ws.Start(ctx)
+}
+
+// Subscribe subscribes to channel, calling fn with each of its events. The
+// subscription is sent once the client is connected, and again after reconnects.
+func (c *CentrifugeClient) Subscribe(ctx context.Context, channel string, fn CentrifugeHandler) error {
+	c.mu.Lock()
+	c.subs[channel] = &centrifugeSub{fn: fn}
+	connected := c.connected
+	var cmd centrifugeCommand
+	if connected {
+		cmd = c.subscribeCommand(channel)
+	}
+	c.mu.Unlock()
+
+	if !connected {
+		return nil
+	}
+	return c.ws.Write(ctx, cmd)
+}
+
+// Unsubscribe unsubscribes from channel.
+func (c *CentrifugeClient) Unsubscribe(ctx context.Context, channel string) error {
+	c.mu.Lock()
+	_, ok := c.subs[channel]
+	delete(c.subs, channel)
+	connected := c.connected
+	c.seq++
+	id := c.seq
+	c.mu.Unlock()
+
+	if !ok || !connected {
+		return nil
+	}
+	return c.ws.Write(ctx, centrifugeCommand{ID: id, Method: centrifugeUnsubscribe, Params: map[string]string{"channel": channel}})
+}
+
+// Publish publishes data to channel, and waits for the server to accept it.
+func (c *CentrifugeClient) Publish(ctx context.Context, channel string, data any) error {
+	_, err := c.request(ctx, centrifugePublish, map[string]any{"channel": channel, "data": data})
+	return err
+}
+
+// RPC sends an rpc to the server's rpc handler, returning the result data.
+func (c *CentrifugeClient) RPC(ctx context.Context, method string, data any) (json.RawMessage, error) {
+	result, err := c.request(ctx, centrifugeRPC, map[string]any{"method": method, "data": data})
+	if err != nil {
+		return nil, err
+	}
+	var rpc struct {
+		Data json.RawMessage `json:"data"`
+	}
+	return rpc.Data, json.Unmarshal(result, &rpc)
+}
+
+func (c *CentrifugeClient) request(ctx context.Context, method int, params any) (json.RawMessage, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	c.mu.Lock()
+	c.seq++
+	id := c.seq
+	c.mu.Unlock()
+
+	rsp, done := c.ws.registerCall(centrifugeCallID(id))
+	defer done()
+
+	if err := c.ws.Write(ctx, centrifugeCommand{ID: id, Method: method, Params: params}); err != nil {
+		return nil, err
+	}
+
+	bts, err := c.ws.awaitCall(ctx, rsp)
+	if err != nil {
+		return nil, err
+	}
+	var reply centrifugeReply
+	if err := json.Unmarshal(bts, &reply); err != nil {
+		return nil, err
+	}
+	if reply.Error != nil {
+		return nil, reply.Error
+	}
+	return reply.Result, nil
+}
+
+// subscribeCommand must be called with the lock held.
+func (c *CentrifugeClient) subscribeCommand(channel string) centrifugeCommand {
+	c.seq++
+	c.pending[c.seq] = channel
+
+	params := map[string]any{"channel": channel}
+	if sub := c.subs[channel]; sub.recoverable {
+		params["recover"] = true
+		params["offset"] = sub.offset
+		params["epoch"] = sub.epoch
+	}
+	return centrifugeCommand{ID: c.seq, Method: centrifugeSubscribe, Params: params}
+}
+
+func (c *CentrifugeClient) onOpen(ws *WSClient) error {
+	token := c.cfg.Token
+	if c.cfg.TokenFunc != nil {
+		var err error
+		if token, err = c.cfg.TokenFunc(); err != nil {
+			return fmt.Errorf("centrifuge token: %w", err)
+		}
+	}
+
+	stop := make(chan struct{})
+	c.mu.Lock()
+	c.seq++
+	c.connectID = c.seq
+	c.stop = stop
+	cmd := centrifugeCommand{ID: c.seq, Method: centrifugeConnect, Params: map[string]any{"token": token, "data": c.cfg.Data}}
+	c.mu.Unlock()
+
+	go c.ping(stop)
+
+	return ws.Write(context.Background(), cmd)
+}
+
+func (c *CentrifugeClient) onClose(_ *WSClient) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected = false
+	c.pending = map[uint32]string{}
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	return nil
+}
+
+func (c *CentrifugeClient) ping(stop chan struct{}) {
+	t := c.ws.clock.NewTicker(c.cfg.Ping)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C():
+			c.mu.Lock()
+			c.seq++
+			id := c.seq
+			c.mu.Unlock()
+			if err := c.ws.Write(context.Background(), centrifugeCommand{ID: id, Method: centrifugePing}); err != nil {
+				c.ws.logger.Debug("centrifuge: ping failed", "error", err.Error())
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// handle handles a frame, which may hold several newline delimited replies.
+func (c *CentrifugeClient) handle(bts []byte) error {
+	for _, line := range bytes.Split(bts, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if err := c.handleReply(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CentrifugeClient) handleReply(bts []byte) error {
+	var reply centrifugeReply
+	if err := json.Unmarshal(bts, &reply); err != nil {
+		return err
+	}
+	if reply.ID == 0 {
+		return c.handlePush(reply.Result)
+	}
+
+	c.mu.Lock()
+	isConnect := reply.ID == c.connectID
+	channel, isSubscribe := c.pending[reply.ID]
+	delete(c.pending, reply.ID)
+	c.mu.Unlock()
+
+	switch {
+	case isConnect:
+		if reply.Error != nil {
+			return reply.Error
+		}
+		return c.onConnect()
+	case isSubscribe:
+		return c.onSubscribe(channel, reply)
+	default:
+		c.ws.deliverCall(centrifugeCallID(reply.ID), bts)
+		return nil
+	}
+}
+
+func (c *CentrifugeClient) onConnect() error {
+	c.mu.Lock()
+	c.connected = true
+	cmds := make([]centrifugeCommand, 0, len(c.subs))
+	for channel := range c.subs {
+		cmds = append(cmds, c.subscribeCommand(channel))
+	}
+	c.mu.Unlock()
+
+	for _, cmd := range cmds {
+		if err := c.ws.Write(context.Background(), cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CentrifugeClient) onSubscribe(channel string, reply centrifugeReply) error {
+	if reply.Error != nil {
+		c.ws.logger.Info("centrifuge: subscribe failed", "channel", channel, "error", reply.Error.Error())
+		return nil
+	}
+
+	var result struct {
+		Recoverable  bool                    `json:"recoverable"`
+		Epoch        string                  `json:"epoch"`
+		Offset       uint64                  `json:"offset"`
+		Publications []centrifugePublication `json:"publications"`
+		Recovered    bool                    `json:"recovered"`
+	}
+	if err := json.Unmarshal(reply.Result, &result); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	sub, ok := c.subs[channel]
+	if ok {
+		if sub.recoverable && !result.Recovered {
+			c.ws.logger.Info("centrifuge: publications could not be recovered", "channel", channel)
+		}
+		sub.recoverable = result.Recoverable
+		sub.epoch = result.Epoch
+		sub.offset = result.Offset
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	for _, pub := range result.Publications {
+		if err := c.publish(channel, sub, pub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CentrifugeClient) handlePush(bts json.RawMessage) error {
+	var push struct {
+		Type    int             `json:"type"`
+		Channel string          `json:"channel"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(bts, &push); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	sub, ok := c.subs[push.Channel]
+	c.mu.Unlock()
+	if !ok {
+		c.ws.logger.Debug("centrifuge: push for unknown channel", "channel", push.Channel, "type", push.Type)
+		return nil
+	}
+
+	switch push.Type {
+	case centrifugePushPublication:
+		var pub centrifugePublication
+		if err := json.Unmarshal(push.Data, &pub); err != nil {
+			return err
+		}
+		return c.publish(push.Channel, sub, pub)
+	case centrifugePushJoin, centrifugePushLeave:
+		var presence struct {
+			Info CentrifugeClientInfo `json:"info"`
+		}
+		if err := json.Unmarshal(push.Data, &presence); err != nil {
+			return err
+		}
+		typ := CentrifugeJoin
+		if push.Type == centrifugePushLeave {
+			typ = CentrifugeLeave
+		}
+		return sub.fn(CentrifugeEvent{Type: typ, Channel: push.Channel, Info: &presence.Info})
+	case centrifugePushUnsub:
+		c.ws.logger.Info("centrifuge: unsubscribed by server", "channel", push.Channel)
+		c.mu.Lock()
+		delete(c.subs, push.Channel)
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// publish records the publication's stream position and passes it to the handler.
+func (c *CentrifugeClient) publish(channel string, sub *centrifugeSub, pub centrifugePublication) error {
+	if pub.Offset != 0 {
+		c.mu.Lock()
+		sub.offset = pub.Offset
+		c.mu.Unlock()
+	}
+	return sub.fn(CentrifugeEvent{
+		Type:    CentrifugePublication,
+		Channel: channel,
+		Data:    pub.Data,
+		Offset:  pub.Offset,
+		Info:    pub.Info,
+	})
+}
+
+func centrifugeCallID(id uint32) string {
+	return "centrifuge:" + strconv.FormatUint(uint64(id), 10)
+}
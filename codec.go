@@ -0,0 +1,56 @@
+package apic
+
+import (
+	"mime"
+	"strings"
+)
+
+// Codec is an Encoder and Decoder pair, along with the content type they speak.
+// Setting a codec on a client sets its Content-Type and Accept headers, or the
+// websocket frame type, to match.
+type Codec interface {
+	ContentType() string
+	Encode(any) ([]byte, error)
+	Decode([]byte, any) error
+}
+
+// NewCodec creates a codec of contentType from an encoder and decoder.
+func NewCodec(contentType string, enc Encoder, dec Decoder) Codec {
+	return funcCodec{contentType: contentType, enc: enc, dec: dec}
+}
+
+type funcCodec struct {
+	contentType string
+	enc         Encoder
+	dec         Decoder
+}
+
+func (c funcCodec) ContentType() string            { return c.contentType }
+func (c funcCodec) Encode(v any) ([]byte, error)   { return c.enc(v) }
+func (c funcCodec) Decode(bts []byte, v any) error { return c.dec(bts, v) }
+
+// The built in codecs.
+var (
+	JSONCodec    = NewCodec("application/json", defaultEncoder, defaultDecoder)
+	XMLCodec     = NewCodec("application/xml", XMLEncoder, XMLDecoder)
+	MsgpackCodec = NewCodec("application/msgpack", MsgpackEncoder, MsgpackDecoder)
+	CBORCodec    = NewCodec("application/cbor", CBOREncoder, CBORDecoder)
+)
+
+// messageTypeFor is the websocket frame type for a content type: text for text/*,
+// json, and xml, including structured syntax suffixes, and binary for the rest.
+func messageTypeFor(contentType string) MessageType {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return MessageBinary
+	}
+	if strings.HasPrefix(mt, "text/") {
+		return MessageText
+	}
+	for _, text := range []string{"json", "xml"} {
+		if strings.HasSuffix(mt, "/"+text) || strings.HasSuffix(mt, "+"+text) {
+			return MessageText
+		}
+	}
+	return MessageBinary
+}
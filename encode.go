@@ -1,7 +1,11 @@
 package apic
 
 import (
+	"bytes"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
 )
 
 type Encoder func(any) ([]byte, error)
@@ -12,3 +16,39 @@ var (
 	defaultEncoder = json.Marshal
 	defaultDecoder = json.Unmarshal
 )
+
+// strictJSONDecoder decodes json like json.Unmarshal, but fails on object keys
+// that don't match a field of the destination.
+func strictJSONDecoder(bts []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(bts))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("json: invalid data after top-level value")
+	}
+	return nil
+}
+
+// StreamDecoder decodes a response body straight from its reader, rather than
+// reading it into memory first.
+type StreamDecoder interface {
+	Decode(io.Reader, any) error
+}
+
+// StreamDecoderFunc adapts a func to a StreamDecoder.
+type StreamDecoderFunc func(io.Reader, any) error
+
+func (fn StreamDecoderFunc) Decode(r io.Reader, v any) error { return fn(r, v) }
+
+// JSONStreamDecoder decodes json bodies as they are read.
+var JSONStreamDecoder StreamDecoder = StreamDecoderFunc(func(r io.Reader, v any) error {
+	return json.NewDecoder(r).Decode(v)
+})
+
+// XMLEncoder and XMLDecoder encode and decode xml bodies.
+var (
+	XMLEncoder Encoder = xml.Marshal
+	XMLDecoder Decoder = xml.Unmarshal
+)
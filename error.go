@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 )
 
 func badStatusError(rsp *http.Response) error {
@@ -33,3 +34,72 @@ func GetErrorCode(err error) int {
 	}
 	return se.code
 }
+
+// ReconnectDurationError is returned by WSClient.Start when the client has been
+// unable to reconnect for longer than the configured maximum reconnect duration.
+type ReconnectDurationError struct {
+	Elapsed time.Duration
+	Err     error
+}
+
+func (e *ReconnectDurationError) Error() string {
+	return fmt.Sprintf("gave up reconnecting after %s: %v", e.Elapsed, e.Err)
+}
+
+func (e *ReconnectDurationError) Unwrap() error {
+	return e.Err
+}
+
+// MaxAttemptsError is returned by WSClient.Start when the client has used up its
+// reconnect attempts.
+type MaxAttemptsError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *MaxAttemptsError) Error() string {
+	return fmt.Sprintf("gave up reconnecting after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *MaxAttemptsError) Unwrap() error {
+	return e.Err
+}
+
+// ForcedReconnectError ends a connection closed by ForceReconnect.
+type ForcedReconnectError struct {
+	Reason string
+}
+
+func (e *ForcedReconnectError) Error() string {
+	return fmt.Sprintf("forced reconnect: %s", e.Reason)
+}
+
+// AcceptError rejects a WSServer handshake with an http status, when returned
+// by the accept auth hook. Message defaults to the status text.
+type AcceptError struct {
+	Status  int
+	Message string
+}
+
+func (e *AcceptError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("handshake rejected: %d %s", e.Status, e.Message)
+	}
+	return fmt.Sprintf("handshake rejected: %d", e.Status)
+}
+
+// CloseError is returned by the connection when the server closes it with a
+// close frame, and is what LastClose reports.
+type CloseError struct {
+	Code   StatusCode
+	Reason string
+	Err    error
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed by server: %s %q", e.Code, e.Reason)
+}
+
+func (e *CloseError) Unwrap() error {
+	return e.Err
+}
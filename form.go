@@ -0,0 +1,123 @@
+package apic
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// FormContentType is the Content-Type of form encoded bodies.
+const FormContentType = "application/x-www-form-urlencoded"
+
+// FormEncoder encodes url.Values, maps of strings or string slices, and structs as
+// form encoded bodies. Struct fields are named by their form tags, falling back to
+// their json tags, then their names, and support "-" and omitempty. Slices and
+// arrays are encoded as repeated keys, and other values with fmt, or MarshalText
+// where they implement it.
+var FormEncoder Encoder = formMarshal
+
+// Form marks a request body to be form encoded, whatever the client's encoder, ie,
+// client.Post("/token", apic.Form(creds), &rsp).
+func Form(v any) any {
+	return formBody{v: v}
+}
+
+type formBody struct {
+	v any
+}
+
+func formMarshal(v any) ([]byte, error) {
+	vals, err := formValues(v)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(vals.Encode()), nil
+}
+
+func formValues(v any) (url.Values, error) {
+	switch v := v.(type) {
+	case formBody:
+		return formValues(v.v)
+	case url.Values:
+		return v, nil
+	case map[string][]string:
+		return url.Values(v), nil
+	case map[string]string:
+		vals := url.Values{}
+		for k, s := range v {
+			vals.Set(k, s)
+		}
+		return vals, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return url.Values{}, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("form: unsupported type %T", v)
+	}
+
+	vals := url.Values{}
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		tag, ok := sf.Tag.Lookup("form")
+		if !ok {
+			tag = sf.Tag.Get("json")
+		}
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = sf.Name
+		}
+		fv := rv.Field(i)
+		if strings.Contains(opts, "omitempty") && isEmptyValue(fv) {
+			continue
+		}
+
+		if (fv.Kind() == reflect.Slice || fv.Kind() == reflect.Array) && !fv.Type().Implements(textMarshalerType) {
+			for j := 0; j < fv.Len(); j++ {
+				s, err := formValue(fv.Index(j))
+				if err != nil {
+					return nil, err
+				}
+				vals.Add(name, s)
+			}
+			continue
+		}
+		s, err := formValue(fv)
+		if err != nil {
+			return nil, err
+		}
+		vals.Set(name, s)
+	}
+	return vals, nil
+}
+
+func formValue(v reflect.Value) (string, error) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", nil
+		}
+		if v.Type().Implements(textMarshalerType) {
+			break
+		}
+		v = v.Elem()
+	}
+	if v.Type().Implements(textMarshalerType) {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	}
+	return fmt.Sprint(v.Interface()), nil
+}
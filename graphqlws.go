@@ -0,0 +1,250 @@
+package apic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GraphQLWSConfig configures a GraphQLWSClient.
+type GraphQLWSConfig struct {
+	// InitPayload is sent as the connection_init payload, typically for auth.
+	InitPayload any
+
+	// KeepAlive, if set, is the interval at which the client pings the server.
+	KeepAlive time.Duration
+}
+
+// GraphQLRequest is a GraphQL operation.
+type GraphQLRequest struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables,omitempty"`
+	OperationName string         `json:"operationName,omitempty"`
+}
+
+// GraphQLError is a single error in a GraphQL result.
+type GraphQLError struct {
+	Message    string         `json:"message"`
+	Path       []any          `json:"path,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+// GraphQLErrors is a list of GraphQL errors as an error.
+type GraphQLErrors []GraphQLError
+
+func (errs GraphQLErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Message
+	}
+	return "graphql: " + strings.Join(msgs, "; ")
+}
+
+// GraphQLHandler receives each result for a subscription.
+type GraphQLHandler func(data json.RawMessage, errs GraphQLErrors) error
+
+// GraphQLTyped adapts a handler taking a decoded data object to a GraphQLHandler.
+func GraphQLTyped[T any](fn func(data T, errs GraphQLErrors) error) GraphQLHandler {
+	return func(raw json.RawMessage, errs GraphQLErrors) error {
+		var data T
+		if len(raw) != 0 && string(raw) != "null" {
+			if err := json.Unmarshal(raw, &data); err != nil {
+				return err
+			}
+		}
+		return fn(data, errs)
+	}
+}
+
+// GraphQLWSClient consumes GraphQL subscriptions using the graphql-transport-ws
+// subprotocol. Subscriptions are (re)sent each time the server acknowledges a
+// connection, so they survive reconnects.
+type GraphQLWSClient struct {
+	ws  *WSClient
+	cfg GraphQLWSConfig
+
+	mu    sync.Mutex
+	acked bool
+	seq   uint64
+	subs  map[string]graphqlSub
+	stop  chan struct{}
+}
+
+type graphqlSub struct {
+	req GraphQLRequest
+	fn  GraphQLHandler
+}
+
+type graphqlMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type graphqlOutbound struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Payload any    `json:"payload,omitempty"`
+}
+
+// NewGraphQLWSClient creates a graphql-transport-ws client for the endpoint.
+// The options are passed along to the underlying WSClient, except for the
+// global message handler, which the GraphQL client owns.
+func NewGraphQLWSClient(endpoint string, cfg GraphQLWSConfig, opts ...WSOption) *GraphQLWSClient {
+	c := &GraphQLWSClient{
+		cfg:  cfg,
+		subs: map[string]graphqlSub{},
+	}
+	opts = append(append([]WSOption{}, opts...), WithWSHandler(c.handle), WithWSEncoder(defaultEncoder))
+	c.ws = NewWSClient(endpoint, opts...)
+	c.ws.addSubprotocols("graphql-transport-ws")
+	c.ws.chainOnOpen(c.onOpen)
+	c.ws.chainOnClose(c.onClose)
+	return c
+}
+
+// WS returns the underlying websocket client.
+func (c *GraphQLWSClient) WS() *WSClient {
+	return c.ws
+}
+
+// Start runs the underlying websocket client. See WSClient.Start.
+func (c *GraphQLWSClient) Start(ctx context.Context) error {
+	return c.ws.Start(ctx)
+}
+
+// Subscribe starts a subscription, calling fn for each result. The subscription
+// is sent as soon as the connection is acknowledged, and again after reconnects.
+func (c *GraphQLWSClient) Subscribe(ctx context.Context, req GraphQLRequest, fn GraphQLHandler) (string, error) {
+	c.mu.Lock()
+	c.seq++
+	id := strconv.FormatUint(c.seq, 10)
+	c.subs[id] = graphqlSub{req: req, fn: fn}
+	acked := c.acked
+	c.mu.Unlock()
+
+	if !acked {
+		return id, nil
+	}
+	return id, c.ws.Write(ctx, graphqlOutbound{ID: id, Type: "subscribe", Payload: req})
+}
+
+// Unsubscribe completes the subscription with the given id.
+func (c *GraphQLWSClient) Unsubscribe(ctx context.Context, id string) error {
+	c.mu.Lock()
+	_, ok := c.subs[id]
+	delete(c.subs, id)
+	acked := c.acked
+	c.mu.Unlock()
+
+	if !ok || !acked {
+		return nil
+	}
+	return c.ws.Write(ctx, graphqlOutbound{ID: id, Type: "complete"})
+}
+
+func (c *GraphQLWSClient) onOpen(ws *WSClient) error {
+	if p := ws.Subprotocol(); p != "graphql-transport-ws" {
+		return fmt.Errorf("graphql: server did not accept graphql-transport-ws subprotocol (got %q)", p)
+	}
+
+	stop := make(chan struct{})
+	c.mu.Lock()
+	c.stop = stop
+	c.mu.Unlock()
+
+	if c.cfg.KeepAlive != 0 {
+		go func() {
+			t := ws.clock.NewTicker(c.cfg.KeepAlive)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C():
+					if err := ws.Write(context.Background(), graphqlOutbound{Type: "ping"}); err != nil {
+						ws.logger.Debug("graphql: keep alive failed", "error", err.Error())
+					}
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+
+	return ws.Write(context.Background(), graphqlOutbound{Type: "connection_init", Payload: c.cfg.InitPayload})
+}
+
+func (c *GraphQLWSClient) onClose(_ *WSClient) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.acked = false
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	return nil
+}
+
+func (c *GraphQLWSClient) handle(bts []byte) error {
+	var msg graphqlMessage
+	if err := json.Unmarshal(bts, &msg); err != nil {
+		return err
+	}
+
+	switch msg.Type {
+	case "connection_ack":
+		c.mu.Lock()
+		c.acked = true
+		pending := make([]graphqlOutbound, 0, len(c.subs))
+		for id, sub := range c.subs {
+			pending = append(pending, graphqlOutbound{ID: id, Type: "subscribe", Payload: sub.req})
+		}
+		c.mu.Unlock()
+		for _, out := range pending {
+			if err := c.ws.Write(context.Background(), out); err != nil {
+				return err
+			}
+		}
+	case "ping":
+		return c.ws.Write(context.Background(), graphqlOutbound{Type: "pong"})
+	case "pong":
+	case "next":
+		var result struct {
+			Data   json.RawMessage `json:"data"`
+			Errors GraphQLErrors   `json:"errors"`
+		}
+		if err := json.Unmarshal(msg.Payload, &result); err != nil {
+			return err
+		}
+		if sub, ok := c.sub(msg.ID, false); ok {
+			return sub.fn(result.Data, result.Errors)
+		}
+	case "error":
+		var errs GraphQLErrors
+		if err := json.Unmarshal(msg.Payload, &errs); err != nil {
+			return err
+		}
+		if sub, ok := c.sub(msg.ID, true); ok {
+			return sub.fn(nil, errs)
+		}
+	case "complete":
+		c.sub(msg.ID, true)
+	default:
+		c.ws.logger.Debug("graphql: unknown message type", "type", msg.Type)
+	}
+	return nil
+}
+
+// sub looks up a subscription by id, optionally removing it.
+func (c *GraphQLWSClient) sub(id string, remove bool) (graphqlSub, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sub, ok := c.subs[id]
+	if remove {
+		delete(c.subs, id)
+	}
+	return sub, ok
+}
@@ -21,8 +21,25 @@ type HTTPClient struct {
 	// encoder is used to encode request bodies
 	encoder Encoder
 
-	// decoder is used to decode response bodies
-	decoder Decoder
+	// decoder is used to decode response bodies, unless one of decoders is
+	// registered for the response's content type
+	decoder  Decoder
+	decoders []contentDecoder
+
+	// decoderType is the content type decoder decodes, if known, and codecAccept
+	// is set when a codec asked for it to be accepted
+	decoderType string
+	codecAccept bool
+
+	// stream, if set, decodes response bodies from the reader in place of decoder,
+	// unless bodies are logged
+	stream StreamDecoder
+
+	// contentType and accept, if set, are sent as the Content-Type header of
+	// requests with a body, and the Accept header of every request. accept is
+	// built once the options are applied, from decoderType and decoders
+	contentType string
+	accept      string
 
 	// logger logs each request and response
 	logger Logger
@@ -48,10 +65,11 @@ type HTTPClient struct {
 
 func NewHTTPClient(root string, opts ...HTTPOption) *HTTPClient {
 	c := &HTTPClient{
-		root:    root,
-		encoder: defaultEncoder,
-		decoder: defaultDecoder,
-		logger:  noLogger{},
+		root:        root,
+		encoder:     defaultEncoder,
+		decoder:     defaultDecoder,
+		decoderType: "application/json",
+		logger:      noLogger{},
 		client: &http.Client{
 			Timeout: time.Second * 5,
 		},
@@ -64,6 +82,10 @@ func NewHTTPClient(root string, opts ...HTTPOption) *HTTPClient {
 		opt(c)
 	}
 
+	if c.codecAccept || len(c.decoders) != 0 {
+		c.accept = c.acceptHeader()
+	}
+
 	return c
 }
 
@@ -92,18 +114,27 @@ func (c *HTTPClient) Patch(path string, data any, dest any) error {
 }
 
 func (c *HTTPClient) doBody(method, path string, data any, dest any) error {
+	encoder, contentType := c.encoder, c.contentType
+	if _, ok := data.(formBody); ok {
+		encoder, contentType = FormEncoder, FormContentType
+	}
+
 	var body io.Reader
 	if data != nil {
-		bts, err := c.encoder(data)
+		bts, err := encoder(data)
 		if err != nil {
 			return err
 		}
 		body = bytes.NewReader(bts)
 	}
-	return c.Do(method, path, body, dest)
+	return c.do(method, path, body, contentType, dest)
 }
 
 func (c *HTTPClient) Do(method, path string, body io.Reader, dest any) error {
+	return c.do(method, path, body, c.contentType, dest)
+}
+
+func (c *HTTPClient) do(method, path string, body io.Reader, contentType string, dest any) error {
 	var bodyLog []byte
 	if c.logBodies && body != nil {
 		var err error
@@ -125,6 +156,13 @@ func (c *HTTPClient) Do(method, path string, body io.Reader, dest any) error {
 		}
 	}
 
+	if contentType != "" && body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.accept != "" {
+		req.Header.Set("Accept", c.accept)
+	}
+
 	if err := c.before(req); err != nil {
 		return err
 	}
@@ -150,9 +188,15 @@ HeaderLoop:
 	}
 	defer resp.Body.Close()
 
-	bts, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
+	respType := resp.Header.Get("Content-Type")
+	stream := c.stream != nil && !c.logBodies && c.registeredDecoder(respType) == nil
+
+	var bts []byte
+	if !stream {
+		bts, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
 	}
 
 	if c.logBodies {
@@ -168,5 +212,8 @@ HeaderLoop:
 		return nil
 	}
 
-	return c.decoder(bts, dest)
+	if stream {
+		return c.stream.Decode(resp.Body, dest)
+	}
+	return c.decoderFor(respType)(bts, dest)
 }
@@ -0,0 +1,68 @@
+package apic
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// contentDecoder is a decoder registered for a response content type.
+type contentDecoder struct {
+	contentType string
+	decoder     Decoder
+}
+
+// decoderFor returns the decoder for a response's Content-Type: the one registered
+// for its media type, or for its structured syntax suffix, ie, application/json for
+// application/problem+json, falling back on the client's decoder.
+func (c *HTTPClient) decoderFor(contentType string) Decoder {
+	if d := c.registeredDecoder(contentType); d != nil {
+		return d
+	}
+	return c.decoder
+}
+
+// registeredDecoder is the decoder registered for contentType, or nil if none is.
+func (c *HTTPClient) registeredDecoder(contentType string) Decoder {
+	if len(c.decoders) == 0 || contentType == "" {
+		return nil
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	for _, cd := range c.decoders {
+		if cd.contentType == mt {
+			return cd.decoder
+		}
+	}
+	if i := strings.LastIndexByte(mt, '+'); i >= 0 {
+		suffixed := mt[:strings.IndexByte(mt, '/')+1] + mt[i+1:]
+		for _, cd := range c.decoders {
+			if cd.contentType == suffixed {
+				return cd.decoder
+			}
+		}
+	}
+	return nil
+}
+
+// acceptHeader is an Accept header listing the client decoder's content type, if
+// known, followed by the registered decoders' content types, in the order they
+// were registered: the first at the default weight of 1, and each after it a
+// tenth lower, down to 0.1.
+func (c *HTTPClient) acceptHeader() string {
+	var types []string
+	if c.decoderType != "" {
+		types = append(types, c.decoderType)
+	}
+	for _, cd := range c.decoders {
+		if cd.contentType != c.decoderType {
+			types = append(types, cd.contentType)
+		}
+	}
+	for i := 1; i < len(types); i++ {
+		types[i] = fmt.Sprintf("%s;q=0.%d", types[i], max(10-i, 1))
+	}
+	return strings.Join(types, ", ")
+}
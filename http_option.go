@@ -2,6 +2,7 @@ package apic
 
 import (
 	"net/http"
+	"strings"
 
 	"golang.org/x/time/rate"
 )
@@ -23,6 +24,79 @@ func WithEncoder(fn func(obj any) ([]byte, error)) HTTPOption {
 func WithDecoder(fn func([]byte, any) error) HTTPOption {
 	return func(c *HTTPClient) {
 		c.decoder = fn
+		c.decoderType = ""
+		c.stream = nil
+	}
+}
+
+// WithStrictJSON decodes json responses with unknown fields disallowed, so fields
+// an api adds, or renames, fail decoding rather than being silently dropped.
+func WithStrictJSON() HTTPOption {
+	return func(c *HTTPClient) {
+		WithDecoder(strictJSONDecoder)(c)
+		c.decoderType = "application/json"
+	}
+}
+
+// WithStreamDecoder decodes response bodies with d straight from the body reader,
+// ie, JSONStreamDecoder, rather than reading them into memory first. Decoders
+// registered with WithDecoderFor still take precedence for their content types.
+func WithStreamDecoder(d StreamDecoder) HTTPOption {
+	return func(c *HTTPClient) {
+		c.stream = d
+	}
+}
+
+// WithXML encodes request bodies and decodes responses as xml, sending
+// Content-Type and Accept headers of application/xml.
+func WithXML() HTTPOption {
+	return WithCodec(XMLCodec)
+}
+
+// WithMsgpack encodes request bodies and decodes responses as MessagePack, sending
+// Content-Type and Accept headers of application/msgpack.
+func WithMsgpack() HTTPOption {
+	return WithCodec(MsgpackCodec)
+}
+
+// WithCBOR encodes request bodies and decodes responses as CBOR, sending
+// Content-Type and Accept headers of application/cbor.
+func WithCBOR() HTTPOption {
+	return WithCodec(CBORCodec)
+}
+
+// WithForm form encodes request bodies. See FormEncoder, and Form for form encoding
+// a single request's body.
+func WithForm() HTTPOption {
+	return func(c *HTTPClient) {
+		c.encoder = FormEncoder
+		c.contentType = FormContentType
+	}
+}
+
+// WithDecoderFor decodes responses with a Content-Type of contentType, ie,
+// "application/xml", with d, rather than the client's decoder, for apis that
+// answer in different formats. Media types with a structured syntax suffix fall
+// back on the suffix's type, ie, application/problem+json on application/json.
+// Requests then accept the client decoder's content type first, json by default,
+// and the registered content types after it, in the order they were registered.
+// The decoder's type is unknown after WithDecoder, and left out.
+func WithDecoderFor(contentType string, d Decoder) HTTPOption {
+	return func(c *HTTPClient) {
+		c.decoders = append(c.decoders, contentDecoder{contentType: strings.ToLower(contentType), decoder: d})
+	}
+}
+
+// WithCodec encodes request bodies and decodes responses with codec, sending its
+// content type as the Content-Type header, and first in the Accept header.
+func WithCodec(codec Codec) HTTPOption {
+	return func(c *HTTPClient) {
+		c.encoder = codec.Encode
+		c.decoder = codec.Decode
+		c.decoderType = codec.ContentType()
+		c.codecAccept = true
+		c.stream = nil
+		c.contentType = codec.ContentType()
 	}
 }
 
@@ -0,0 +1,219 @@
+package apic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// JSONRPCClient speaks JSON-RPC 2.0 over a WSClient. Responses are matched
+// to their requests by id, and server initiated notifications are routed
+// to handlers registered by method.
+type JSONRPCClient struct {
+	ws *WSClient
+
+	handlersMu sync.RWMutex
+	handlers   map[string]func(params json.RawMessage) error
+}
+
+// NewJSONRPCClient creates a JSON-RPC client for the endpoint. The options are
+// passed along to the underlying WSClient, with the exception of the global
+// message handler, which the JSON-RPC client owns.
+func NewJSONRPCClient(endpoint string, opts ...WSOption) *JSONRPCClient {
+	c := &JSONRPCClient{
+		handlers: map[string]func(json.RawMessage) error{},
+	}
+	opts = append(append([]WSOption{}, opts...), WithWSHandler(c.handle), WithWSEncoder(defaultEncoder), WithCallIDs(attachJSONID, jsonrpcResponseID))
+	c.ws = NewWSClient(endpoint, opts...)
+	return c
+}
+
+// WS returns the underlying websocket client.
+func (c *JSONRPCClient) WS() *WSClient {
+	return c.ws
+}
+
+// Start runs the underlying websocket client. See WSClient.Start.
+func (c *JSONRPCClient) Start(ctx context.Context) error {
+	return c.ws.Start(ctx)
+}
+
+// Handle registers a handler for server initiated notifications of the given method.
+func (c *JSONRPCClient) Handle(method string, fn func(params json.RawMessage) error) {
+	c.handlersMu.Lock()
+	defer c.handlersMu.Unlock()
+	c.handlers[method] = fn
+}
+
+// Call invokes method with params, and decodes the result in to dest.
+// If the server responds with an error, it is returned as a *JSONRPCError.
+func (c *JSONRPCClient) Call(ctx context.Context, method string, params any, dest any) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	id := c.ws.callSeq.Add(1)
+	rsp, done := c.ws.registerCall(strconv.FormatUint(id, 10))
+	defer done()
+
+	if err := c.ws.Write(ctx, newJSONRPCRequest(&id, method, params)); err != nil {
+		return err
+	}
+
+	bts, err := c.ws.awaitCall(ctx, rsp)
+	if err != nil {
+		return err
+	}
+	return decodeJSONRPCResponse(bts, dest)
+}
+
+// Notify sends a notification, which the server does not respond to.
+func (c *JSONRPCClient) Notify(ctx context.Context, method string, params any) error {
+	return c.ws.Write(ctx, newJSONRPCRequest(nil, method, params))
+}
+
+// JSONRPCBatchElem is a single call in a batch. After BatchCall returns,
+// Error holds the per call error, if any, and the result has been decoded
+// in to Result.
+type JSONRPCBatchElem struct {
+	Method string
+	Params any
+	Result any
+	Error  error
+}
+
+// BatchCall sends all elems as a single batch request, and waits for all of
+// their responses. The returned error is only non nil if the batch as a whole
+// failed; per call errors are set on each element.
+func (c *JSONRPCClient) BatchCall(ctx context.Context, elems []JSONRPCBatchElem) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if len(elems) == 0 {
+		return nil
+	}
+
+	var (
+		reqs = make([]jsonrpcRequest, len(elems))
+		rsps = make([]chan []byte, len(elems))
+	)
+	for i, elem := range elems {
+		id := c.ws.callSeq.Add(1)
+		rsp, done := c.ws.registerCall(strconv.FormatUint(id, 10))
+		defer done()
+		reqs[i] = newJSONRPCRequest(&id, elem.Method, elem.Params)
+		rsps[i] = rsp
+	}
+
+	if err := c.ws.Write(ctx, reqs); err != nil {
+		return err
+	}
+
+	for i := range elems {
+		bts, err := c.ws.awaitCall(ctx, rsps[i])
+		if err != nil {
+			return err
+		}
+		elems[i].Error = decodeJSONRPCResponse(bts, elems[i].Result)
+	}
+	return nil
+}
+
+// handle is the global handler for the underlying websocket. Batch responses
+// are split up and matched individually; anything not matched to a call is
+// treated as a notification.
+func (c *JSONRPCClient) handle(bts []byte) error {
+	var batch []json.RawMessage
+	if err := json.Unmarshal(bts, &batch); err == nil {
+		for _, msg := range batch {
+			if err := c.handle(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if c.ws.resolveCall(bts) {
+		return nil
+	}
+
+	var note struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(bts, &note); err != nil {
+		return fmt.Errorf("jsonrpc: %w", err)
+	}
+	if note.Method == "" {
+		c.ws.logMessage("jsonrpc: dropping unmatched message", MessageText, bts)
+		return nil
+	}
+
+	c.handlersMu.RLock()
+	fn, ok := c.handlers[note.Method]
+	c.handlersMu.RUnlock()
+	if !ok {
+		c.ws.logger.Debug("jsonrpc: no handler for notification", "method", note.Method)
+		return nil
+	}
+	return fn(note.Params)
+}
+
+// jsonrpcResponseID is the CallIDExtractor for JSON-RPC: it reads the id of
+// responses only, so that a server request whose id happens to match a waiting
+// call isn't taken for its response.
+func jsonrpcResponseID(msg []byte) (string, bool) {
+	var obj struct {
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal(msg, &obj); err != nil || obj.Method != "" {
+		return "", false
+	}
+	return extractJSONID(msg)
+}
+
+// JSONRPCError is an error object returned by a JSON-RPC server.
+type JSONRPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
+
+type jsonrpcRequest struct {
+	Version string  `json:"jsonrpc"`
+	ID      *uint64 `json:"id,omitempty"`
+	Method  string  `json:"method"`
+	Params  any     `json:"params,omitempty"`
+}
+
+func newJSONRPCRequest(id *uint64, method string, params any) jsonrpcRequest {
+	return jsonrpcRequest{
+		Version: "2.0",
+		ID:      id,
+		Method:  method,
+		Params:  params,
+	}
+}
+
+func decodeJSONRPCResponse(bts []byte, dest any) error {
+	var rsp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *JSONRPCError   `json:"error"`
+	}
+	if err := json.Unmarshal(bts, &rsp); err != nil {
+		return err
+	}
+	if rsp.Error != nil {
+		return rsp.Error
+	}
+	if dest == nil || len(rsp.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(rsp.Result, dest)
+}
@@ -0,0 +1,655 @@
+package apic
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MQTTConfig configures an MQTTClient.
+type MQTTConfig struct {
+	ClientID string
+	Username string
+	Password string
+
+	// ProtocolVersion is 4 for MQTT 3.1.1 (the default), or 5 for MQTT 5.
+	// MQTT 5 properties are never sent, and are skipped when received.
+	ProtocolVersion byte
+
+	// KeepAlive is the keep alive interval sent in CONNECT, and the interval at
+	// which the client pings the broker. A broker that doesn't answer a ping
+	// within half of KeepAlive is taken as gone, and the client reconnects.
+	// Defaults to 60 seconds.
+	KeepAlive time.Duration
+
+	// CleanSession asks the broker to discard any previous session state.
+	CleanSession bool
+
+	// Will, if set, is published by the broker if the client disconnects uncleanly.
+	Will *MQTTMessage
+}
+
+// MQTTMessage is an application message published to, or received from, the broker.
+type MQTTMessage struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+}
+
+// MQTTHandler receives each message matching a subscription.
+type MQTTHandler func(MQTTMessage) error
+
+// MQTTConnectError is returned when the broker refuses the connection.
+type MQTTConnectError struct {
+	Code byte
+}
+
+func (e *MQTTConnectError) Error() string {
+	return fmt.Sprintf("mqtt: connection refused: code %d", e.Code)
+}
+
+// MQTTSubscribeError is returned when the broker refuses a subscription.
+type MQTTSubscribeError struct {
+	Filter string
+	Code   byte
+}
+
+func (e *MQTTSubscribeError) Error() string {
+	return fmt.Sprintf("mqtt: subscribe to %s refused: code %d", e.Filter, e.Code)
+}
+
+var errMQTTMalformed = errors.New("mqtt: malformed packet")
+
+const (
+	mqttConnect     = 1
+	mqttConnack     = 2
+	mqttPublish     = 3
+	mqttPuback      = 4
+	mqttPubrec      = 5
+	mqttPubrel      = 6
+	mqttPubcomp     = 7
+	mqttSubscribe   = 8
+	mqttSuback      = 9
+	mqttUnsubscribe = 10
+	mqttUnsuback    = 11
+	mqttPingreq     = 12
+	mqttPingresp    = 13
+	mqttDisconnect  = 14
+)
+
+// MQTTClient speaks MQTT over WSClient's binary transport, using the "mqtt"
+// subprotocol. Subscriptions are re-sent after each CONNACK, so they survive
+// reconnects. Message handlers run in order on their own goroutine, apart from
+// the reader, so they may Subscribe, Unsubscribe and Publish.
+type MQTTClient struct {
+	ws  *WSClient
+	cfg MQTTConfig
+
+	mu        sync.Mutex
+	connected bool
+	packetID  uint16
+	subs      map[string]mqttSub
+	buf       []byte
+	stop      chan struct{}
+
+	// inbox holds the connection's messages for the dispatcher, which ready wakes
+	inbox []mqttDelivery
+	ready chan struct{}
+
+	// lastPingResp is when the broker last answered a ping, in unix nanos
+	lastPingResp atomic.Int64
+}
+
+// mqttDelivery is a received message, with the handlers it matched and the
+// packet id to acknowledge it with once they have run.
+type mqttDelivery struct {
+	msg MQTTMessage
+	fns []MQTTHandler
+	id  []byte
+}
+
+type mqttSub struct {
+	qos byte
+	fn  MQTTHandler
+}
+
+// NewMQTTClient creates an MQTT client for the endpoint (ie, wss://broker/mqtt). The
+// options are passed along to the underlying WSClient, except for the global message
+// handler, which the MQTT client owns.
+func NewMQTTClient(endpoint string, cfg MQTTConfig, opts ...WSOption) *MQTTClient {
+	if cfg.ProtocolVersion == 0 {
+		cfg.ProtocolVersion = 4
+	}
+	if cfg.KeepAlive <= 0 {
+		cfg.KeepAlive = time.Second * 60
+	}
+	c := &MQTTClient{
+		cfg:  cfg,
+		subs: map[string]mqttSub{},
+	}
+	opts = append(append([]WSOption{}, opts...), WithWSHandler(c.handle))
+	c.ws = NewWSClient(endpoint, opts...)
+	c.ws.addSubprotocols("mqtt")
+	c.ws.chainOnOpen(c.onOpen)
+	c.ws.chainOnClose(c.onClose)
+	return c
+}
+
+// WS returns the underlying websocket client.
+func (c *MQTTClient) WS() *WSClient {
+	return c.ws
+}
+
+// Start runs the underlying websocket client. See WSClient.Start.
+func (c *MQTTClient) Start(ctx context.Context) error {
+	return c.ws.Start(ctx)
+}
+
+// Subscribe subscribes to the topic filter at the given QoS, calling fn with each
+// matching message. If connected, Subscribe waits for the broker's SUBACK.
+func (c *MQTTClient) Subscribe(ctx context.Context, filter string, qos byte, fn MQTTHandler) error {
+	c.mu.Lock()
+	c.subs[filter] = mqttSub{qos: qos, fn: fn}
+	connected := c.connected
+	var id uint16
+	if connected {
+		id = c.nextPacketID()
+	}
+	c.mu.Unlock()
+
+	if !connected {
+		return nil
+	}
+
+	bts, err := c.request(ctx, id, c.subscribePacket(id, filter, qos))
+	if err != nil {
+		return err
+	}
+	if codes := c.ackCodes(bts); len(codes) != 0 && codes[0] >= 0x80 {
+		return &MQTTSubscribeError{Filter: filter, Code: codes[0]}
+	}
+	return nil
+}
+
+// Unsubscribe unsubscribes from the topic filter. Like Subscribe, it waits for the
+// broker's acknowledgement while connected.
+func (c *MQTTClient) Unsubscribe(ctx context.Context, filter string) error {
+	c.mu.Lock()
+	_, ok := c.subs[filter]
+	delete(c.subs, filter)
+	connected := c.connected
+	id := c.nextPacketID()
+	c.mu.Unlock()
+
+	if !ok || !connected {
+		return nil
+	}
+
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, id)
+	c.writeProperties(&body)
+	mqttWriteString(&body, filter)
+	_, err := c.request(ctx, id, mqttPacket(mqttUnsubscribe, 0x02, body.Bytes()))
+	return err
+}
+
+// Publish publishes msg. For QoS 1 and 2, Publish waits for the broker to
+// complete the acknowledgement flow.
+func (c *MQTTClient) Publish(ctx context.Context, msg MQTTMessage) error {
+	var (
+		body  bytes.Buffer
+		flags = msg.QoS << 1
+		id    uint16
+	)
+	if msg.Retain {
+		flags |= 0x01
+	}
+	mqttWriteString(&body, msg.Topic)
+	if msg.QoS > 0 {
+		c.mu.Lock()
+		id = c.nextPacketID()
+		c.mu.Unlock()
+		binary.Write(&body, binary.BigEndian, id)
+	}
+	c.writeProperties(&body)
+	body.Write(msg.Payload)
+	pkt := mqttPacket(mqttPublish, flags, body.Bytes())
+
+	if msg.QoS == 0 {
+		return c.write(ctx, pkt)
+	}
+	_, err := c.request(ctx, id, pkt)
+	return err
+}
+
+// request writes pkt, and waits for the acknowledgement carrying packet id.
+func (c *MQTTClient) request(ctx context.Context, id uint16, pkt []byte) ([]byte, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	rsp, done := c.ws.registerCall(mqttCallID(id))
+	defer done()
+
+	if err := c.write(ctx, pkt); err != nil {
+		return nil, err
+	}
+	return c.ws.awaitCall(ctx, rsp)
+}
+
+func (c *MQTTClient) write(ctx context.Context, pkt []byte) error {
+	return c.ws.SendBinary(ctx, pkt)
+}
+
+// nextPacketID must be called with the lock held.
+func (c *MQTTClient) nextPacketID() uint16 {
+	c.packetID++
+	if c.packetID == 0 {
+		c.packetID++
+	}
+	return c.packetID
+}
+
+// writeProperties writes an empty property set, for MQTT 5.
+func (c *MQTTClient) writeProperties(b *bytes.Buffer) {
+	if c.cfg.ProtocolVersion >= 5 {
+		b.WriteByte(0)
+	}
+}
+
+func (c *MQTTClient) subscribePacket(id uint16, filter string, qos byte) []byte {
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, id)
+	c.writeProperties(&body)
+	mqttWriteString(&body, filter)
+	body.WriteByte(qos)
+	return mqttPacket(mqttSubscribe, 0x02, body.Bytes())
+}
+
+func (c *MQTTClient) onOpen(_ *WSClient) error {
+	var (
+		body  bytes.Buffer
+		flags byte
+	)
+	if c.cfg.Username != "" {
+		flags |= 0x80
+	}
+	if c.cfg.Password != "" {
+		flags |= 0x40
+	}
+	if w := c.cfg.Will; w != nil {
+		flags |= 0x04 | w.QoS<<3
+		if w.Retain {
+			flags |= 0x20
+		}
+	}
+	if c.cfg.CleanSession {
+		flags |= 0x02
+	}
+
+	mqttWriteString(&body, "MQTT")
+	body.WriteByte(c.cfg.ProtocolVersion)
+	body.WriteByte(flags)
+	binary.Write(&body, binary.BigEndian, uint16(c.cfg.KeepAlive/time.Second))
+	c.writeProperties(&body)
+	mqttWriteString(&body, c.cfg.ClientID)
+	if w := c.cfg.Will; w != nil {
+		c.writeProperties(&body)
+		mqttWriteString(&body, w.Topic)
+		binary.Write(&body, binary.BigEndian, uint16(len(w.Payload)))
+		body.Write(w.Payload)
+	}
+	if c.cfg.Username != "" {
+		mqttWriteString(&body, c.cfg.Username)
+	}
+	if c.cfg.Password != "" {
+		mqttWriteString(&body, c.cfg.Password)
+	}
+
+	stop, ready := make(chan struct{}), make(chan struct{}, 1)
+	c.mu.Lock()
+	c.buf = nil
+	c.stop = stop
+	c.inbox = nil
+	c.ready = ready
+	c.mu.Unlock()
+
+	c.lastPingResp.Store(c.ws.clock.Now().UnixNano())
+	go c.keepAlive(stop)
+	go c.dispatch(stop, ready)
+
+	return c.write(context.Background(), mqttPacket(mqttConnect, 0, body.Bytes()))
+}
+
+func (c *MQTTClient) onClose(_ *WSClient) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected = false
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	return nil
+}
+
+// keepAlive pings the broker every KeepAlive, ticking twice as often to
+// reconnect if a ping goes unanswered for half of KeepAlive.
+func (c *MQTTClient) keepAlive(stop chan struct{}) {
+	t := c.ws.clock.NewTicker(max(c.cfg.KeepAlive/2, 1))
+	defer t.Stop()
+	var sent time.Time
+	for tick := 1; ; tick++ {
+		select {
+		case now := <-t.C():
+			if !sent.IsZero() && sent.UnixNano() > c.lastPingResp.Load() && now.Sub(sent) >= c.cfg.KeepAlive/2 {
+				c.ws.logger.Info("mqtt: ping unanswered")
+				c.ws.ForceReconnect("mqtt ping timeout")
+				return
+			}
+			if tick%2 != 0 {
+				continue
+			}
+			if err := c.write(context.Background(), mqttPacket(mqttPingreq, 0, nil)); err != nil {
+				c.ws.logger.Debug("mqtt: ping failed", "error", err.Error())
+				continue
+			}
+			sent = now
+		case <-stop:
+			return
+		}
+	}
+}
+
+// dispatch runs the handlers for the connection's messages in order, and
+// acknowledges each after, until stop closes.
+func (c *MQTTClient) dispatch(stop, ready chan struct{}) {
+	for {
+		select {
+		case <-ready:
+		case <-stop:
+			return
+		}
+
+		c.mu.Lock()
+		inbox := c.inbox
+		c.inbox = nil
+		c.mu.Unlock()
+
+		for _, d := range inbox {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := c.deliver(d); err != nil {
+				c.ws.logger.Info("mqtt: message handling failed", "error", err.Error())
+				c.ws.ForceReconnect("mqtt handler error")
+				return
+			}
+		}
+	}
+}
+
+func (c *MQTTClient) deliver(d mqttDelivery) error {
+	for _, fn := range d.fns {
+		if err := fn(d.msg); err != nil {
+			return err
+		}
+	}
+
+	switch d.msg.QoS {
+	case 1:
+		return c.write(context.Background(), mqttPacket(mqttPuback, 0, d.id))
+	case 2:
+		return c.write(context.Background(), mqttPacket(mqttPubrec, 0, d.id))
+	}
+	return nil
+}
+
+// handle buffers inbound bytes, as packets may span websocket frames, and
+// handles each complete packet.
+func (c *MQTTClient) handle(bts []byte) error {
+	c.mu.Lock()
+	c.buf = append(c.buf, bts...)
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+		pkt, n, err := mqttNextPacket(c.buf)
+		if n > 0 {
+			c.buf = c.buf[n:]
+		}
+		c.mu.Unlock()
+		if err != nil || pkt == nil {
+			return err
+		}
+		if err := c.handlePacket(pkt[0]>>4, pkt[0]&0x0f, pkt[1:]); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *MQTTClient) handlePacket(typ, flags byte, body []byte) error {
+	switch typ {
+	case mqttConnack:
+		if len(body) < 2 {
+			return errMQTTMalformed
+		}
+		if body[1] != 0 {
+			return &MQTTConnectError{Code: body[1]}
+		}
+		return c.onConnack()
+	case mqttPublish:
+		return c.onPublish(flags, body)
+	case mqttPuback, mqttPubcomp, mqttSuback, mqttUnsuback:
+		if len(body) < 2 {
+			return errMQTTMalformed
+		}
+		c.ws.deliverCall(mqttCallID(binary.BigEndian.Uint16(body)), body)
+	case mqttPubrec:
+		if len(body) < 2 {
+			return errMQTTMalformed
+		}
+		return c.write(context.Background(), mqttPacket(mqttPubrel, 0x02, body[:2]))
+	case mqttPubrel:
+		if len(body) < 2 {
+			return errMQTTMalformed
+		}
+		return c.write(context.Background(), mqttPacket(mqttPubcomp, 0, body[:2]))
+	case mqttPingresp:
+		c.lastPingResp.Store(c.ws.clock.Now().UnixNano())
+	case mqttDisconnect:
+		c.ws.logger.Info("mqtt: disconnected by broker")
+	default:
+		c.ws.logger.Debug("mqtt: unexpected packet", "type", typ)
+	}
+	return nil
+}
+
+func (c *MQTTClient) onConnack() error {
+	c.mu.Lock()
+	c.connected = true
+	pkts := make([][]byte, 0, len(c.subs))
+	for filter, sub := range c.subs {
+		pkts = append(pkts, c.subscribePacket(c.nextPacketID(), filter, sub.qos))
+	}
+	c.mu.Unlock()
+
+	for _, pkt := range pkts {
+		if err := c.write(context.Background(), pkt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *MQTTClient) onPublish(flags byte, body []byte) error {
+	msg := MQTTMessage{
+		QoS:    (flags >> 1) & 0x03,
+		Retain: flags&0x01 != 0,
+	}
+
+	topic, body, err := mqttReadString(body)
+	if err != nil {
+		return err
+	}
+	msg.Topic = topic
+
+	var id []byte
+	if msg.QoS > 0 {
+		if len(body) < 2 {
+			return errMQTTMalformed
+		}
+		id, body = body[:2], body[2:]
+	}
+	if c.cfg.ProtocolVersion >= 5 {
+		if body, err = mqttSkipProperties(body); err != nil {
+			return err
+		}
+	}
+	msg.Payload = body
+
+	// handlers run on the dispatcher, so that ones waiting on an ack don't
+	// hold up the reader delivering it
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := mqttDelivery{msg: msg, id: id}
+	for filter, sub := range c.subs {
+		if mqttTopicMatch(filter, topic) {
+			d.fns = append(d.fns, sub.fn)
+		}
+	}
+	c.inbox = append(c.inbox, d)
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// ackCodes returns the return codes of a SUBACK body.
+func (c *MQTTClient) ackCodes(body []byte) []byte {
+	if len(body) < 2 {
+		return nil
+	}
+	body = body[2:]
+	if c.cfg.ProtocolVersion >= 5 {
+		var err error
+		if body, err = mqttSkipProperties(body); err != nil {
+			return nil
+		}
+	}
+	return body
+}
+
+func mqttCallID(id uint16) string {
+	return "mqtt:" + strconv.FormatUint(uint64(id), 10)
+}
+
+// mqttTopicMatch reports whether topic matches filter, honoring the + and # wildcards.
+func mqttTopicMatch(filter, topic string) bool {
+	fs, ts := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) {
+			return false
+		}
+		if f != "+" && f != ts[i] {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
+
+func mqttPacket(typ, flags byte, body []byte) []byte {
+	out := []byte{typ<<4 | flags}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(out, body...)
+}
+
+// mqttNextPacket returns the first complete packet in buf (fixed header byte followed
+// by the body) and the number of bytes it consumed, or a nil packet if buf doesn't
+// yet hold a complete packet.
+func mqttNextPacket(buf []byte) ([]byte, int, error) {
+	if len(buf) < 2 {
+		return nil, 0, nil
+	}
+	length, n, err := mqttVarint(buf[1:])
+	if err != nil || n == 0 {
+		return nil, 0, err
+	}
+	total := 1 + n + length
+	if len(buf) < total {
+		return nil, 0, nil
+	}
+	pkt := append([]byte{buf[0]}, buf[1+n:total]...)
+	return pkt, total, nil
+}
+
+// mqttVarint decodes a variable byte integer, returning the number of bytes read,
+// or zero if b is incomplete.
+func mqttVarint(b []byte) (int, int, error) {
+	var (
+		value int
+		mult  = 1
+	)
+	for i := 0; i < 4; i++ {
+		if i >= len(b) {
+			return 0, 0, nil
+		}
+		value += int(b[i]&0x7f) * mult
+		if b[i]&0x80 == 0 {
+			return value, i + 1, nil
+		}
+		mult *= 128
+	}
+	return 0, 0, errMQTTMalformed
+}
+
+func mqttSkipProperties(b []byte) ([]byte, error) {
+	length, n, err := mqttVarint(b)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 || len(b) < n+length {
+		return nil, errMQTTMalformed
+	}
+	return b[n+length:], nil
+}
+
+func mqttWriteString(b *bytes.Buffer, s string) {
+	binary.Write(b, binary.BigEndian, uint16(len(s)))
+	b.WriteString(s)
+}
+
+func mqttReadString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errMQTTMalformed
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errMQTTMalformed
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
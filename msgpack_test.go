@@ -0,0 +1,139 @@
+package apic
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMsgpackEncode(t *testing.T) {
+	tests := []struct {
+		in   any
+		want []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{1, []byte{0x01}},
+		{-1, []byte{0xff}},
+		{-33, []byte{0xd0, 0xdf}},
+		{256, []byte{0xcd, 0x01, 0x00}},
+		{uint64(math.MaxUint64), []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"a", []byte{0xa1, 'a'}},
+		{[]byte{1}, []byte{0xc4, 0x01, 0x01}},
+		{[]int{1, 2}, []byte{0x92, 0x01, 0x02}},
+		{MsgpackExt{Type: 5, Data: []byte{1}}, []byte{0xd4, 0x05, 0x01}},
+		{MsgpackExt{Type: 5, Data: make([]byte, 3)}, []byte{0xc7, 0x03, 0x05, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		got, err := MsgpackEncoder(tt.in)
+		if err != nil {
+			t.Errorf("encode %v: %v", tt.in, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("encode %v: got % x, want % x", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	type inner struct {
+		N int `json:"n"`
+	}
+	type value struct {
+		Int     int64             `json:"int"`
+		Uint    uint64            `json:"uint"`
+		Float   float64           `json:"float"`
+		Str     string            `json:"str"`
+		Long    string            `json:"long"`
+		Bytes   []byte            `json:"bytes"`
+		Slice   []inner           `json:"slice"`
+		Map     map[string]string `json:"map"`
+		Ptr     *inner            `json:"ptr"`
+		Omitted string            `json:"omitted,omitempty"`
+		Ext     MsgpackExt        `json:"ext"`
+		Time    time.Time         `json:"time"`
+		Skipped string            `json:"-"`
+	}
+	in := value{
+		Int:   math.MinInt64,
+		Uint:  math.MaxUint64,
+		Float: -0.25,
+		Str:   "héllo",
+		Long:  strings.Repeat("x", 70000),
+		Bytes: []byte{0, 1, 2},
+		Slice: []inner{{1}, {2}},
+		Map:   map[string]string{"a": "b"},
+		Ptr:   &inner{3},
+		Ext:   MsgpackExt{Type: 7, Data: make([]byte, 300)},
+		Time:  time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+	}
+	bts, err := MsgpackEncoder(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out value
+	if err := MsgpackDecoder(bts, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("got %+v, want %+v", out, in)
+	}
+}
+
+func TestMsgpackDecodeExt(t *testing.T) {
+	tests := []struct {
+		in   []byte
+		want any
+	}{
+		{[]byte{0xd4, 0x01, 0xaa}, MsgpackExt{Type: 1, Data: []byte{0xaa}}},
+		{[]byte{0xd5, 0x01, 0xaa, 0xbb}, MsgpackExt{Type: 1, Data: []byte{0xaa, 0xbb}}},
+		{append([]byte{0xd8, 0x01}, make([]byte, 16)...), MsgpackExt{Type: 1, Data: make([]byte, 16)}},
+		{[]byte{0xc8, 0x00, 0x01, 0x02, 0xaa}, MsgpackExt{Type: 2, Data: []byte{0xaa}}},
+		{[]byte{0xc9, 0x00, 0x00, 0x00, 0x01, 0x02, 0xaa}, MsgpackExt{Type: 2, Data: []byte{0xaa}}},
+		{[]byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x01}, time.Unix(1, 0).UTC()},
+	}
+	for _, tt := range tests {
+		var got any
+		if err := MsgpackDecoder(tt.in, &got); err != nil {
+			t.Errorf("decode % x: %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("decode % x: got %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMsgpackMalformed(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":           {},
+		"truncated str":   {0xa5, 'a'},
+		"truncated array": {0x92, 0x01},
+		"truncated int":   {0xcd, 0x01},
+		"huge bin":        {0xc6, 0xff, 0xff, 0xff, 0xff},
+		"huge map":        {0xdf, 0xff, 0xff, 0xff, 0xff},
+		"unused type":     {0xc1},
+		"trailing data":   {0x01, 0x02},
+		"bad timestamp":   {0xd4, 0xff, 0x00},
+		"too deep":        bytes.Repeat([]byte{0x91}, 50000),
+	}
+	for name, in := range tests {
+		var v any
+		if err := MsgpackDecoder(in, &v); err == nil {
+			t.Errorf("%s: decoded % .16x without error", name, in)
+		}
+	}
+}
+
+func TestMsgpackNonFinite(t *testing.T) {
+	for _, f := range []any{math.NaN(), math.Inf(1), float32(math.Inf(-1))} {
+		if _, err := MsgpackEncoder(f); err == nil {
+			t.Errorf("encoded %v without error", f)
+		}
+	}
+}
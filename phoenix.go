@@ -0,0 +1,353 @@
+package apic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PhoenixConfig configures a PhoenixClient.
+type PhoenixConfig struct {
+	// Heartbeat is the interval at which heartbeats are sent on the phoenix topic.
+	// A heartbeat that hasn't been replied to by the next one has the client
+	// reconnect. Defaults to 30 seconds.
+	Heartbeat time.Duration
+
+	// RejoinDelay is how long after a channel errors, ie, as its process on the
+	// server crashed, that the client rejoins it. Defaults to 1 second.
+	RejoinDelay time.Duration
+}
+
+// PhoenixHandler receives each event broadcast on a joined topic.
+type PhoenixHandler func(event string, payload json.RawMessage) error
+
+// PhoenixReplyError is returned when the server replies to a join or push
+// with a non ok status.
+type PhoenixReplyError struct {
+	Status   string
+	Response json.RawMessage
+}
+
+func (e *PhoenixReplyError) Error() string {
+	return fmt.Sprintf("phoenix: reply status %s: %s", e.Status, string(e.Response))
+}
+
+// PhoenixClient speaks the Phoenix Channels v2 protocol over WSClient. Joined
+// topics are rejoined each time the socket reconnects, and after they error.
+type PhoenixClient struct {
+	ws  *WSClient
+	cfg PhoenixConfig
+
+	mu        sync.Mutex
+	connected bool
+	ref       uint64
+	channels  map[string]*phoenixChannel
+	stop      chan struct{}
+
+	// heartbeatRef is the ref of the heartbeat awaiting its reply, if any
+	heartbeatRef string
+}
+
+type phoenixChannel struct {
+	joinRef string
+	payload any
+	fn      PhoenixHandler
+}
+
+// NewPhoenixClient creates a Phoenix Channels client for the endpoint, which should be
+// the socket's websocket url (ie, wss://host/socket/websocket). The serializer version
+// parameter is added if missing. The options are passed along to the underlying
+// WSClient, except for the global message handler, which the Phoenix client owns.
+func NewPhoenixClient(endpoint string, cfg PhoenixConfig, opts ...WSOption) *PhoenixClient {
+	if cfg.Heartbeat <= 0 {
+		cfg.Heartbeat = time.Second * 30
+	}
+	if cfg.RejoinDelay <= 0 {
+		cfg.RejoinDelay = time.Second
+	}
+	c := &PhoenixClient{
+		cfg:      cfg,
+		channels: map[string]*phoenixChannel{},
+	}
+	opts = append(append([]WSOption{}, opts...), WithWSHandler(c.handle), WithWSEncoder(defaultEncoder))
+	c.ws = NewWSClient(phoenixEndpoint(endpoint), opts...)
+	c.ws.chainOnOpen(c.onOpen)
+	c.ws.chainOnClose(c.onClose)
+	return c
+}
+
+// WS returns the underlying websocket client.
+func (c *PhoenixClient) WS() *WSClient {
+	return c.ws
+}
+
+// Start runs the underlying websocket client. See WSClient.Start.
+func (c *PhoenixClient) Start(ctx context.Context) error {
+	return c.ws.Start(ctx)
+}
+
+// Join joins topic, calling fn for each event broadcast on it. If the socket is
+// connected, Join waits for the server's reply and returns its response; otherwise
+// the topic is joined once the socket connects. Join must not be called from
+// within a handler while connected, as the reply would never be read.
+func (c *PhoenixClient) Join(ctx context.Context, topic string, payload any, fn PhoenixHandler) (json.RawMessage, error) {
+	c.mu.Lock()
+	ch := &phoenixChannel{payload: payload, fn: fn}
+	c.channels[topic] = ch
+	connected := c.connected
+	var ref string
+	if connected {
+		ref = c.nextRef()
+		ch.joinRef = ref
+	}
+	c.mu.Unlock()
+
+	if !connected {
+		return nil, nil
+	}
+	return c.request(ctx, ref, ref, topic, "phx_join", payload)
+}
+
+// Leave leaves topic.
+func (c *PhoenixClient) Leave(ctx context.Context, topic string) error {
+	c.mu.Lock()
+	ch, ok := c.channels[topic]
+	delete(c.channels, topic)
+	connected := c.connected
+	ref := c.nextRef()
+	c.mu.Unlock()
+
+	if !ok || !connected {
+		return nil
+	}
+	return c.write(ctx, ch.joinRef, ref, topic, "phx_leave", struct{}{})
+}
+
+// Push sends an event on a joined topic, and waits for the server's reply.
+func (c *PhoenixClient) Push(ctx context.Context, topic, event string, payload any) (json.RawMessage, error) {
+	c.mu.Lock()
+	var joinRef string
+	if ch, ok := c.channels[topic]; ok {
+		joinRef = ch.joinRef
+	}
+	ref := c.nextRef()
+	c.mu.Unlock()
+
+	return c.request(ctx, joinRef, ref, topic, event, payload)
+}
+
+// request sends a message and waits for the phx_reply carrying its ref.
+func (c *PhoenixClient) request(ctx context.Context, joinRef, ref, topic, event string, payload any) (json.RawMessage, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	rsp, done := c.ws.registerCall(phoenixCallID(ref))
+	defer done()
+
+	if err := c.write(ctx, joinRef, ref, topic, event, payload); err != nil {
+		return nil, err
+	}
+
+	bts, err := c.ws.awaitCall(ctx, rsp)
+	if err != nil {
+		return nil, err
+	}
+
+	var reply struct {
+		Status   string          `json:"status"`
+		Response json.RawMessage `json:"response"`
+	}
+	if err := json.Unmarshal(bts, &reply); err != nil {
+		return nil, err
+	}
+	if reply.Status != "ok" {
+		return nil, &PhoenixReplyError{Status: reply.Status, Response: reply.Response}
+	}
+	return reply.Response, nil
+}
+
+// nextRef must be called with the lock held.
+func (c *PhoenixClient) nextRef() string {
+	c.ref++
+	return strconv.FormatUint(c.ref, 10)
+}
+
+func (c *PhoenixClient) write(ctx context.Context, joinRef, ref, topic, event string, payload any) error {
+	msg := []any{nil, ref, topic, event, payload}
+	if joinRef != "" {
+		msg[0] = joinRef
+	}
+	return c.ws.Write(ctx, msg)
+}
+
+func (c *PhoenixClient) onOpen(ws *WSClient) error {
+	stop := make(chan struct{})
+
+	c.mu.Lock()
+	c.connected = true
+	c.stop = stop
+	c.heartbeatRef = ""
+	type join struct {
+		topic string
+		ref   string
+		ch    *phoenixChannel
+	}
+	joins := make([]join, 0, len(c.channels))
+	for topic, ch := range c.channels {
+		ch.joinRef = c.nextRef()
+		joins = append(joins, join{topic: topic, ref: ch.joinRef, ch: ch})
+	}
+	c.mu.Unlock()
+
+	go c.heartbeat(stop)
+
+	// rejoin replies aren't awaited here, as the read loop isn't running
+	// until onOpen returns; error replies are logged by the handler.
+	for _, j := range joins {
+		if err := c.write(context.Background(), j.ref, j.ref, j.topic, "phx_join", j.ch.payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *PhoenixClient) onClose(_ *WSClient) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected = false
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	return nil
+}
+
+// heartbeat sends a heartbeat every Heartbeat, reconnecting if the last one
+// is still unanswered.
+func (c *PhoenixClient) heartbeat(stop chan struct{}) {
+	t := c.ws.clock.NewTicker(c.cfg.Heartbeat)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C():
+			c.mu.Lock()
+			missed := c.heartbeatRef != ""
+			ref := c.nextRef()
+			c.heartbeatRef = ref
+			c.mu.Unlock()
+			if missed {
+				c.ws.logger.Info("phoenix: heartbeat timed out")
+				c.ws.ForceReconnect("phoenix heartbeat timeout")
+				return
+			}
+			if err := c.write(context.Background(), "", ref, "phoenix", "heartbeat", struct{}{}); err != nil {
+				c.ws.logger.Debug("phoenix: heartbeat failed", "error", err.Error())
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// rejoin joins topic again after RejoinDelay, unless it has since been left or
+// rejoined, or the socket has disconnected.
+func (c *PhoenixClient) rejoin(topic string, ch *phoenixChannel, stop chan struct{}) {
+	t := c.ws.clock.NewTimer(c.cfg.RejoinDelay)
+	defer t.Stop()
+	select {
+	case <-t.C():
+	case <-stop:
+		return
+	}
+
+	c.mu.Lock()
+	if c.channels[topic] != ch || c.stop != stop {
+		c.mu.Unlock()
+		return
+	}
+	ch.joinRef = c.nextRef()
+	ref := ch.joinRef
+	c.mu.Unlock()
+
+	// the reply isn't awaited, like rejoins on connect
+	if err := c.write(context.Background(), ref, ref, topic, "phx_join", ch.payload); err != nil {
+		c.ws.logger.Debug("phoenix: rejoin failed", "topic", topic, "error", err.Error())
+	}
+}
+
+func (c *PhoenixClient) handle(bts []byte) error {
+	var (
+		joinRef, ref *string
+		topic, event string
+		payload      json.RawMessage
+	)
+	msg := []any{&joinRef, &ref, &topic, &event, &payload}
+	if err := json.Unmarshal(bts, &msg); err != nil {
+		return fmt.Errorf("phoenix: %w", err)
+	}
+
+	if event == "phx_reply" {
+		if topic == "phoenix" && ref != nil {
+			c.mu.Lock()
+			if c.heartbeatRef == *ref {
+				c.heartbeatRef = ""
+			}
+			c.mu.Unlock()
+			return nil
+		}
+		if ref != nil && c.ws.deliverCall(phoenixCallID(*ref), payload) {
+			return nil
+		}
+		var reply struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(payload, &reply); err == nil && reply.Status != "ok" {
+			c.ws.logger.Info("phoenix: error reply", "topic", topic, "payload", string(payload))
+		}
+		return nil
+	}
+
+	c.mu.Lock()
+	ch, ok := c.channels[topic]
+	current := ok && joinRef != nil && *joinRef == ch.joinRef
+	stop := c.stop
+	c.mu.Unlock()
+	if !ok {
+		c.ws.logger.Debug("phoenix: message for unjoined topic", "topic", topic, "event", event)
+		return nil
+	}
+
+	switch event {
+	case "phx_error":
+		c.ws.logger.Info("phoenix: channel phx_error", "topic", topic)
+		if current && stop != nil {
+			go c.rejoin(topic, ch, stop)
+		}
+	case "phx_close":
+		c.ws.logger.Info("phoenix: channel phx_close", "topic", topic)
+	}
+	return ch.fn(event, payload)
+}
+
+func phoenixCallID(ref string) string {
+	return "phoenix:" + ref
+}
+
+// phoenixEndpoint adds the v2 serializer version parameter to endpoint, if missing.
+func phoenixEndpoint(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	q := u.Query()
+	if q.Get("vsn") == "" {
+		q.Set("vsn", "2.0.0")
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
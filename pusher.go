@@ -0,0 +1,300 @@
+package apic
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// PusherAuthorizer produces the auth signature (and, for presence channels, the
+// channel data) needed to subscribe to a private or presence channel. Typically
+// this calls the application's auth endpoint.
+type PusherAuthorizer func(socketID, channel string) (auth, channelData string, err error)
+
+// PusherConfig configures a PusherClient.
+type PusherConfig struct {
+	// Authorizer is required to subscribe to private- and presence- channels.
+	Authorizer PusherAuthorizer
+}
+
+// PusherHandler receives each event on a subscribed channel. data has been unwrapped
+// from its string encoding where the server sent it as one.
+type PusherHandler func(event string, data json.RawMessage) error
+
+// PusherError is returned when the server sends a pusher:error event.
+type PusherError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *PusherError) Error() string {
+	return fmt.Sprintf("pusher error %d: %s", e.Code, e.Message)
+}
+
+// PusherSigner returns an authorizer that signs subscriptions locally with the app's
+// key and secret. userData is used as the channel data for presence channels.
+func PusherSigner(key, secret string, userData func(channel string) (string, error)) PusherAuthorizer {
+	return func(socketID, channel string) (string, string, error) {
+		toSign := socketID + ":" + channel
+		var channelData string
+		if strings.HasPrefix(channel, "presence-") && userData != nil {
+			var err error
+			if channelData, err = userData(channel); err != nil {
+				return "", "", err
+			}
+			toSign += ":" + channelData
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(toSign))
+		return key + ":" + hex.EncodeToString(mac.Sum(nil)), channelData, nil
+	}
+}
+
+// PusherClient speaks the Pusher channels protocol over WSClient, including private
+// channel auth and presence channel member tracking. Subscriptions are (re)sent after
+// each connection_established event, so they survive reconnects.
+type PusherClient struct {
+	ws  *WSClient
+	cfg PusherConfig
+
+	mu       sync.Mutex
+	socketID string
+	subs     map[string]PusherHandler
+	members  map[string]map[string]json.RawMessage
+}
+
+type pusherMessage struct {
+	Event   string          `json:"event"`
+	Channel string          `json:"channel,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+type pusherOutbound struct {
+	Event   string `json:"event"`
+	Channel string `json:"channel,omitempty"`
+	Data    any    `json:"data"`
+}
+
+// NewPusherClient creates a Pusher client for the endpoint, which should be the full
+// app url (ie, wss://ws-mt1.pusher.com/app/KEY?protocol=7). The options are passed along
+// to the underlying WSClient, except for the global message handler, which the Pusher
+// client owns.
+func NewPusherClient(endpoint string, cfg PusherConfig, opts ...WSOption) *PusherClient {
+	c := &PusherClient{
+		cfg:     cfg,
+		subs:    map[string]PusherHandler{},
+		members: map[string]map[string]json.RawMessage{},
+	}
+	opts = append(append([]WSOption{}, opts...), WithWSHandler(c.handle), WithWSEncoder(defaultEncoder))
+	c.ws = NewWSClient(endpoint, opts...)
+	c.ws.chainOnClose(c.onClose)
+	return c
+}
+
+// WS returns the underlying websocket client.
+func (c *PusherClient) WS() *WSClient {
+	return c.ws
+}
+
+// Start runs the underlying websocket client. See WSClient.Start.
+func (c *PusherClient) Start(ctx context.Context) error {
+	return c.ws.Start(ctx)
+}
+
+// SocketID returns the socket id of the current connection, or "" if not connected.
+func (c *PusherClient) SocketID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.socketID
+}
+
+// Subscribe subscribes to channel, calling fn with each of its events.
+func (c *PusherClient) Subscribe(ctx context.Context, channel string, fn PusherHandler) error {
+	c.mu.Lock()
+	c.subs[channel] = fn
+	socketID := c.socketID
+	c.mu.Unlock()
+
+	if socketID == "" {
+		return nil
+	}
+	return c.subscribe(ctx, socketID, channel)
+}
+
+// Unsubscribe unsubscribes from channel.
+func (c *PusherClient) Unsubscribe(ctx context.Context, channel string) error {
+	c.mu.Lock()
+	_, ok := c.subs[channel]
+	delete(c.subs, channel)
+	delete(c.members, channel)
+	socketID := c.socketID
+	c.mu.Unlock()
+
+	if !ok || socketID == "" {
+		return nil
+	}
+	return c.ws.Write(ctx, pusherOutbound{Event: "pusher:unsubscribe", Data: map[string]string{"channel": channel}})
+}
+
+// Trigger sends a client event on a private or presence channel. Pusher requires
+// client event names to be prefixed with "client-".
+func (c *PusherClient) Trigger(ctx context.Context, channel, event string, data any) error {
+	return c.ws.Write(ctx, pusherOutbound{Event: event, Channel: channel, Data: data})
+}
+
+// Members returns the current members of a subscribed presence channel, keyed
+// by user id with their user info.
+func (c *PusherClient) Members(channel string) map[string]json.RawMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]json.RawMessage, len(c.members[channel]))
+	for id, info := range c.members[channel] {
+		out[id] = info
+	}
+	return out
+}
+
+func (c *PusherClient) subscribe(ctx context.Context, socketID, channel string) error {
+	data := map[string]string{"channel": channel}
+	if strings.HasPrefix(channel, "private-") || strings.HasPrefix(channel, "presence-") {
+		if c.cfg.Authorizer == nil {
+			return fmt.Errorf("pusher: no authorizer for channel %s", channel)
+		}
+		auth, channelData, err := c.cfg.Authorizer(socketID, channel)
+		if err != nil {
+			return fmt.Errorf("pusher: authorize %s: %w", channel, err)
+		}
+		data["auth"] = auth
+		if channelData != "" {
+			data["channel_data"] = channelData
+		}
+	}
+	return c.ws.Write(ctx, pusherOutbound{Event: "pusher:subscribe", Data: data})
+}
+
+func (c *PusherClient) onClose(_ *WSClient) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.socketID = ""
+	c.members = map[string]map[string]json.RawMessage{}
+	return nil
+}
+
+func (c *PusherClient) handle(bts []byte) error {
+	var msg pusherMessage
+	if err := json.Unmarshal(bts, &msg); err != nil {
+		return err
+	}
+	data := pusherUnwrap(msg.Data)
+
+	switch msg.Event {
+	case "pusher:connection_established":
+		var est struct {
+			SocketID string `json:"socket_id"`
+		}
+		if err := json.Unmarshal(data, &est); err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.socketID = est.SocketID
+		channels := make([]string, 0, len(c.subs))
+		for channel := range c.subs {
+			channels = append(channels, channel)
+		}
+		c.mu.Unlock()
+		for _, channel := range channels {
+			if err := c.subscribe(context.Background(), est.SocketID, channel); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "pusher:ping":
+		return c.ws.Write(context.Background(), pusherOutbound{Event: "pusher:pong", Data: struct{}{}})
+	case "pusher:pong":
+		return nil
+	case "pusher:error":
+		perr := &PusherError{}
+		if err := json.Unmarshal(data, perr); err != nil {
+			return err
+		}
+		return perr
+	case "pusher_internal:subscription_succeeded":
+		c.presenceSync(msg.Channel, data)
+	case "pusher_internal:member_added":
+		c.presenceMember(msg.Channel, data, true)
+	case "pusher_internal:member_removed":
+		c.presenceMember(msg.Channel, data, false)
+	}
+
+	c.mu.Lock()
+	fn, ok := c.subs[msg.Channel]
+	c.mu.Unlock()
+	if !ok {
+		if msg.Channel != "" {
+			c.ws.logger.Debug("pusher: event for unknown channel", "channel", msg.Channel, "event", msg.Event)
+		}
+		return nil
+	}
+	return fn(msg.Event, data)
+}
+
+func (c *PusherClient) presenceSync(channel string, data json.RawMessage) {
+	if !strings.HasPrefix(channel, "presence-") {
+		return
+	}
+	var state struct {
+		Presence struct {
+			Hash map[string]json.RawMessage `json:"hash"`
+		} `json:"presence"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		c.ws.logger.Debug("pusher: bad presence data", "channel", channel, "error", err.Error())
+		return
+	}
+	if state.Presence.Hash == nil {
+		state.Presence.Hash = map[string]json.RawMessage{}
+	}
+	c.mu.Lock()
+	c.members[channel] = state.Presence.Hash
+	c.mu.Unlock()
+}
+
+func (c *PusherClient) presenceMember(channel string, data json.RawMessage, added bool) {
+	var member struct {
+		UserID   string          `json:"user_id"`
+		UserInfo json.RawMessage `json:"user_info"`
+	}
+	if err := json.Unmarshal(data, &member); err != nil {
+		c.ws.logger.Debug("pusher: bad member data", "channel", channel, "error", err.Error())
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	members, ok := c.members[channel]
+	if !ok {
+		return
+	}
+	if added {
+		members[member.UserID] = member.UserInfo
+	} else {
+		delete(members, member.UserID)
+	}
+}
+
+// pusherUnwrap decodes data that was sent as a json encoded string.
+func pusherUnwrap(data json.RawMessage) json.RawMessage {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return data
+	}
+	if json.Valid([]byte(s)) {
+		return json.RawMessage(s)
+	}
+	return data
+}
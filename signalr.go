@@ -0,0 +1,348 @@
+package apic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// SignalRConfig configures a SignalRClient.
+type SignalRConfig struct {
+	// KeepAlive is the interval at which the client pings the server.
+	// Defaults to 15 seconds, matching the ASP.NET Core server default.
+	KeepAlive time.Duration
+
+	// ServerTimeout is how long the server may go silent before the client takes
+	// the connection as dead, and reconnects. Defaults to twice KeepAlive, which
+	// is the server's own keep alive interval by default.
+	ServerTimeout time.Duration
+}
+
+// SignalRHandler receives the arguments of a hub method invoked by the server.
+type SignalRHandler func(args []json.RawMessage) error
+
+// SignalRError is an error returned by the hub, either for an invocation
+// or when the server rejects the handshake.
+type SignalRError struct {
+	Message string
+}
+
+func (e *SignalRError) Error() string {
+	return "signalr: " + e.Message
+}
+
+const signalRRecordSeparator = 0x1e
+
+const (
+	signalRInvocation       = 1
+	signalRStreamItem       = 2
+	signalRCompletion       = 3
+	signalRStreamInvocation = 4
+	signalRCancelInvocation = 5
+	signalRPing             = 6
+	signalRClose            = 7
+)
+
+var errSignalRHandshake = errors.New("signalr: handshake not completed")
+
+// SignalRClient speaks the ASP.NET Core SignalR json hub protocol over WSClient.
+// The endpoint should be the hub's websocket url; the http negotiate step is not
+// performed, so the hub must allow skipping negotiation.
+type SignalRClient struct {
+	ws  *WSClient
+	cfg SignalRConfig
+
+	mu         sync.Mutex
+	handshaken bool
+	seq        uint64
+	handlers   map[string]SignalRHandler
+	streams    map[string]func(json.RawMessage) error
+	stop       chan struct{}
+
+	// lastRecv is when the server last sent anything, in unix nanos
+	lastRecv atomic.Int64
+}
+
+type signalRMessage struct {
+	Type         int               `json:"type"`
+	InvocationID string            `json:"invocationId,omitempty"`
+	Target       string            `json:"target,omitempty"`
+	Arguments    []json.RawMessage `json:"arguments,omitempty"`
+	Item         json.RawMessage   `json:"item,omitempty"`
+	Result       json.RawMessage   `json:"result,omitempty"`
+	Error        string            `json:"error,omitempty"`
+
+	AllowReconnect bool `json:"allowReconnect,omitempty"`
+}
+
+type signalROutbound struct {
+	Type         int    `json:"type"`
+	InvocationID string `json:"invocationId,omitempty"`
+	Target       string `json:"target,omitempty"`
+	Arguments    *[]any `json:"arguments,omitempty"`
+}
+
+func signalRInvocationMessage(typ int, id, target string, args []any) signalROutbound {
+	if args == nil {
+		args = []any{}
+	}
+	return signalROutbound{Type: typ, InvocationID: id, Target: target, Arguments: &args}
+}
+
+// NewSignalRClient creates a SignalR hub client for the endpoint. The options are
+// passed along to the underlying WSClient, except for the global message handler,
+// which the SignalR client owns.
+func NewSignalRClient(endpoint string, cfg SignalRConfig, opts ...WSOption) *SignalRClient {
+	if cfg.KeepAlive <= 0 {
+		cfg.KeepAlive = time.Second * 15
+	}
+	if cfg.ServerTimeout <= 0 {
+		cfg.ServerTimeout = cfg.KeepAlive * 2
+	}
+	c := &SignalRClient{
+		cfg:      cfg,
+		handlers: map[string]SignalRHandler{},
+		streams:  map[string]func(json.RawMessage) error{},
+	}
+	opts = append(append([]WSOption{}, opts...), WithWSHandler(c.handle))
+	c.ws = NewWSClient(endpoint, opts...)
+	c.ws.chainOnOpen(c.onOpen)
+	c.ws.chainOnClose(c.onClose)
+	return c
+}
+
+// WS returns the underlying websocket client.
+func (c *SignalRClient) WS() *WSClient {
+	return c.ws
+}
+
+// Start runs the underlying websocket client. See WSClient.Start.
+func (c *SignalRClient) Start(ctx context.Context) error {
+	return c.ws.Start(ctx)
+}
+
+// On registers a handler for a hub method the server invokes on the client.
+func (c *SignalRClient) On(target string, fn SignalRHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[target] = fn
+}
+
+// Send invokes a hub method without waiting for a result.
+func (c *SignalRClient) Send(ctx context.Context, target string, args ...any) error {
+	return c.write(ctx, signalRInvocationMessage(signalRInvocation, "", target, args))
+}
+
+// Invoke invokes a hub method and waits for its completion, returning the result.
+func (c *SignalRClient) Invoke(ctx context.Context, target string, args ...any) (json.RawMessage, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	id := c.nextID()
+	rsp, done := c.ws.registerCall(signalRCallID(id))
+	defer done()
+
+	if err := c.write(ctx, signalRInvocationMessage(signalRInvocation, id, target, args)); err != nil {
+		return nil, err
+	}
+
+	bts, err := c.ws.awaitCall(ctx, rsp)
+	if err != nil {
+		return nil, err
+	}
+	return signalRCompletionResult(bts)
+}
+
+// Stream invokes a streaming hub method, calling fn with each item until the
+// server completes the stream. Canceling ctx cancels the stream on the server.
+func (c *SignalRClient) Stream(ctx context.Context, target string, fn func(item json.RawMessage) error, args ...any) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	id := c.nextID()
+	rsp, done := c.ws.registerCall(signalRCallID(id))
+	defer done()
+
+	c.mu.Lock()
+	c.streams[id] = fn
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.streams, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(ctx, signalRInvocationMessage(signalRStreamInvocation, id, target, args)); err != nil {
+		return err
+	}
+
+	select {
+	case bts := <-rsp:
+		_, err := signalRCompletionResult(bts)
+		return err
+	case <-ctx.Done():
+		if err := c.write(context.Background(), signalROutbound{Type: signalRCancelInvocation, InvocationID: id}); err != nil {
+			c.ws.logger.Debug("signalr: cancel invocation failed", "error", err.Error())
+		}
+		return ctx.Err()
+	}
+}
+
+func (c *SignalRClient) nextID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	return strconv.FormatUint(c.seq, 10)
+}
+
+func (c *SignalRClient) write(ctx context.Context, obj any) error {
+	bts, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return c.ws.writeFrame(ctx, MessageText, append(bts, signalRRecordSeparator))
+}
+
+func (c *SignalRClient) onOpen(ws *WSClient) error {
+	stop := make(chan struct{})
+	c.mu.Lock()
+	c.handshaken = false
+	c.stop = stop
+	c.mu.Unlock()
+
+	c.lastRecv.Store(ws.clock.Now().UnixNano())
+	go c.keepAlive(ws, stop)
+
+	return c.write(context.Background(), map[string]any{"protocol": "json", "version": 1})
+}
+
+// keepAlive pings the server every KeepAlive, and reconnects once it has been
+// silent for ServerTimeout.
+func (c *SignalRClient) keepAlive(ws *WSClient, stop chan struct{}) {
+	t := ws.clock.NewTicker(min(c.cfg.KeepAlive, c.cfg.ServerTimeout))
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C():
+			if now.Sub(time.Unix(0, c.lastRecv.Load())) > c.cfg.ServerTimeout {
+				ws.logger.Info("signalr: server timed out")
+				ws.ForceReconnect("signalr server timeout")
+				return
+			}
+			if err := c.write(context.Background(), signalROutbound{Type: signalRPing}); err != nil {
+				ws.logger.Debug("signalr: ping failed", "error", err.Error())
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (c *SignalRClient) onClose(_ *WSClient) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	return nil
+}
+
+func (c *SignalRClient) handle(bts []byte) error {
+	c.lastRecv.Store(c.ws.clock.Now().UnixNano())
+	for _, rec := range bytes.Split(bts, []byte{signalRRecordSeparator}) {
+		if len(rec) == 0 {
+			continue
+		}
+		if err := c.handleRecord(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *SignalRClient) handleRecord(rec []byte) error {
+	c.mu.Lock()
+	handshaken := c.handshaken
+	c.handshaken = true
+	c.mu.Unlock()
+
+	// the first record is always the handshake response
+	if !handshaken {
+		var hs struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(rec, &hs); err != nil {
+			return errSignalRHandshake
+		}
+		if hs.Error != "" {
+			return &SignalRError{Message: hs.Error}
+		}
+		c.ws.logger.Info("signalr: handshake complete")
+		return nil
+	}
+
+	var msg signalRMessage
+	if err := json.Unmarshal(rec, &msg); err != nil {
+		return err
+	}
+
+	switch msg.Type {
+	case signalRInvocation:
+		c.mu.Lock()
+		fn, ok := c.handlers[msg.Target]
+		c.mu.Unlock()
+		if !ok {
+			c.ws.logger.Debug("signalr: no handler for target", "target", msg.Target)
+			return nil
+		}
+		return fn(msg.Arguments)
+	case signalRStreamItem:
+		c.mu.Lock()
+		fn, ok := c.streams[msg.InvocationID]
+		c.mu.Unlock()
+		if ok {
+			return fn(msg.Item)
+		}
+	case signalRCompletion:
+		c.ws.deliverCall(signalRCallID(msg.InvocationID), rec)
+	case signalRPing:
+	case signalRClose:
+		// the server is done with the session: reconnect if it allows, and
+		// otherwise stop, as Start would if closed
+		if msg.AllowReconnect {
+			c.ws.logger.Info("signalr: closed by server, reconnecting", "error", msg.Error)
+			c.ws.ForceReconnect("signalr closed by server")
+			return nil
+		}
+		c.ws.logger.Info("signalr: closed by server", "error", msg.Error)
+		go c.ws.CloseWithStatus(context.Background(), websocket.StatusNormalClosure, "", 0)
+	default:
+		c.ws.logger.Debug("signalr: unknown message type", "type", msg.Type)
+	}
+	return nil
+}
+
+func signalRCompletionResult(bts []byte) (json.RawMessage, error) {
+	var msg signalRMessage
+	if err := json.Unmarshal(bts, &msg); err != nil {
+		return nil, err
+	}
+	if msg.Error != "" {
+		return nil, &SignalRError{Message: msg.Error}
+	}
+	return msg.Result, nil
+}
+
+func signalRCallID(id string) string {
+	return "signalr:" + id
+}
@@ -0,0 +1,339 @@
+package apic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SocketIOConfig configures a SocketIOClient.
+type SocketIOConfig struct {
+	// Namespaces to connect to. Defaults to just the main namespace, "/".
+	Namespaces []string
+
+	// Auth is sent as the CONNECT payload for each namespace.
+	Auth any
+}
+
+// SocketIOHandler receives the arguments of an event.
+type SocketIOHandler func(args []json.RawMessage) error
+
+// SocketIOConnectError is returned when the server refuses a namespace connection.
+type SocketIOConnectError struct {
+	Namespace string
+	Data      json.RawMessage
+}
+
+func (e *SocketIOConnectError) Error() string {
+	return fmt.Sprintf("socket.io: connect to %s refused: %s", e.Namespace, string(e.Data))
+}
+
+// SocketIOClient speaks Socket.IO v5 (Engine.IO v4) over WSClient, using
+// the websocket transport only. Namespaces are reconnected each time the
+// Engine.IO session is reopened, and the session is reopened if the server's
+// pings stop arriving. Binary packets aren't supported, and are dropped.
+type SocketIOClient struct {
+	ws  *WSClient
+	cfg SocketIOConfig
+
+	mu       sync.RWMutex
+	handlers map[string]SocketIOHandler
+	ackSeq   uint64
+	stop     chan struct{}
+
+	// lastPing is when the server last pinged, in unix nanos
+	lastPing atomic.Int64
+}
+
+// NewSocketIOClient creates a Socket.IO client for the endpoint, which should be the
+// full socket.io path (ie, wss://host/socket.io/). The Engine.IO query parameters are
+// added if missing. The options are passed along to the underlying WSClient, except
+// for the global message handler, which the Socket.IO client owns.
+func NewSocketIOClient(endpoint string, cfg SocketIOConfig, opts ...WSOption) *SocketIOClient {
+	if len(cfg.Namespaces) == 0 {
+		cfg.Namespaces = []string{"/"}
+	}
+	c := &SocketIOClient{
+		cfg:      cfg,
+		handlers: map[string]SocketIOHandler{},
+	}
+	opts = append(append([]WSOption{}, opts...), WithWSMessageHandler(c.handleMessage))
+	c.ws = NewWSClient(engineIOEndpoint(endpoint), opts...)
+	c.ws.chainOnClose(c.onClose)
+	return c
+}
+
+// WS returns the underlying websocket client.
+func (c *SocketIOClient) WS() *WSClient {
+	return c.ws
+}
+
+// Start runs the underlying websocket client. See WSClient.Start.
+func (c *SocketIOClient) Start(ctx context.Context) error {
+	return c.ws.Start(ctx)
+}
+
+// On registers a handler for an event in a namespace.
+func (c *SocketIOClient) On(namespace, event string, fn SocketIOHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[namespace+"\x00"+event] = fn
+}
+
+// Emit sends an event to a namespace.
+func (c *SocketIOClient) Emit(ctx context.Context, namespace, event string, args ...any) error {
+	pkt, err := socketIOEvent(namespace, "", event, args)
+	if err != nil {
+		return err
+	}
+	return c.ws.writeFrame(ctx, MessageText, pkt)
+}
+
+// EmitWithAck sends an event to a namespace, and waits for the server's acknowledgement.
+func (c *SocketIOClient) EmitWithAck(ctx context.Context, namespace, event string, args ...any) ([]json.RawMessage, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	c.mu.Lock()
+	c.ackSeq++
+	ackID := strconv.FormatUint(c.ackSeq, 10)
+	c.mu.Unlock()
+
+	pkt, err := socketIOEvent(namespace, ackID, event, args)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, done := c.ws.registerCall(socketIOCallID(namespace, ackID))
+	defer done()
+
+	if err := c.ws.writeFrame(ctx, MessageText, pkt); err != nil {
+		return nil, err
+	}
+
+	bts, err := c.ws.awaitCall(ctx, rsp)
+	if err != nil {
+		return nil, err
+	}
+	var out []json.RawMessage
+	return out, json.Unmarshal(bts, &out)
+}
+
+func (c *SocketIOClient) handleMessage(typ MessageType, bts []byte) error {
+	if typ == MessageBinary {
+		c.ws.logger.Debug("socket.io: dropping binary attachment")
+		return nil
+	}
+	return c.handle(bts)
+}
+
+func (c *SocketIOClient) handle(bts []byte) error {
+	if len(bts) == 0 {
+		return nil
+	}
+
+	// engine.io packet
+	switch bts[0] {
+	case '0':
+		c.open(bts[1:])
+		return c.connectNamespaces()
+	case '1':
+		c.ws.logger.Info("socket.io: engine closed by server")
+		return nil
+	case '2':
+		c.lastPing.Store(c.ws.clock.Now().UnixNano())
+		return c.ws.writeFrame(context.Background(), MessageText, []byte("3"))
+	case '3', '6':
+		return nil
+	case '4':
+		return c.handlePacket(string(bts[1:]))
+	default:
+		c.ws.logger.Debug("socket.io: unknown engine packet", "type", string(bts[0]))
+		return nil
+	}
+}
+
+// handlePacket handles a socket.io packet, carried by an engine.io message packet.
+func (c *SocketIOClient) handlePacket(pkt string) error {
+	if pkt == "" {
+		return nil
+	}
+	typ, rest := pkt[0], pkt[1:]
+	if typ == '5' || typ == '6' {
+		c.ws.logger.Debug("socket.io: dropping unsupported binary packet")
+		return nil
+	}
+
+	nsp := "/"
+	if strings.HasPrefix(rest, "/") {
+		var ok bool
+		nsp, rest, ok = strings.Cut(rest, ",")
+		if !ok {
+			rest = ""
+		}
+	}
+
+	i := 0
+	for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+		i++
+	}
+	ackID, data := rest[:i], rest[i:]
+
+	switch typ {
+	case '0':
+		c.ws.logger.Info("socket.io: namespace connected", "namespace", nsp)
+	case '1':
+		c.ws.logger.Info("socket.io: namespace disconnected by server", "namespace", nsp)
+	case '2':
+		return c.handleEvent(nsp, ackID, data)
+	case '3':
+		c.ws.deliverCall(socketIOCallID(nsp, ackID), []byte(data))
+	case '4':
+		return &SocketIOConnectError{Namespace: nsp, Data: json.RawMessage(data)}
+	}
+	return nil
+}
+
+func (c *SocketIOClient) handleEvent(nsp, ackID, data string) error {
+	var args []json.RawMessage
+	if err := json.Unmarshal([]byte(data), &args); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	var event string
+	if err := json.Unmarshal(args[0], &event); err != nil {
+		return err
+	}
+
+	c.mu.RLock()
+	fn, ok := c.handlers[nsp+"\x00"+event]
+	c.mu.RUnlock()
+	if !ok {
+		c.ws.logger.Debug("socket.io: no handler for event", "namespace", nsp, "event", event)
+	} else if err := fn(args[1:]); err != nil {
+		return err
+	}
+
+	if ackID == "" {
+		return nil
+	}
+	return c.ws.writeFrame(context.Background(), MessageText, []byte("43"+socketIONamespacePrefix(nsp)+ackID+"[]"))
+}
+
+// open starts watching for the server's pings, given the engine.io open packet's
+// data. The server pings every pingInterval, and is given pingTimeout more.
+func (c *SocketIOClient) open(data []byte) {
+	var hs struct {
+		PingInterval int64 `json:"pingInterval"`
+		PingTimeout  int64 `json:"pingTimeout"`
+	}
+	if err := json.Unmarshal(data, &hs); err != nil || hs.PingInterval <= 0 {
+		return
+	}
+	interval := time.Duration(hs.PingInterval) * time.Millisecond
+	deadline := interval + time.Duration(max(hs.PingTimeout, 0))*time.Millisecond
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil {
+		close(c.stop)
+	}
+	c.stop = make(chan struct{})
+	c.lastPing.Store(c.ws.clock.Now().UnixNano())
+	go c.watchPings(interval, deadline, c.stop)
+}
+
+// watchPings forces a reconnect once the server hasn't pinged within deadline.
+func (c *SocketIOClient) watchPings(interval, deadline time.Duration, stop chan struct{}) {
+	t := c.ws.clock.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C():
+			if now.Sub(time.Unix(0, c.lastPing.Load())) > deadline {
+				c.ws.logger.Info("socket.io: server ping missed")
+				c.ws.ForceReconnect("socket.io ping timeout")
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (c *SocketIOClient) onClose(_ *WSClient) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	return nil
+}
+
+func (c *SocketIOClient) connectNamespaces() error {
+	var auth []byte
+	if c.cfg.Auth != nil {
+		var err error
+		if auth, err = json.Marshal(c.cfg.Auth); err != nil {
+			return err
+		}
+	}
+	for _, nsp := range c.cfg.Namespaces {
+		pkt := "40" + socketIONamespacePrefix(nsp) + string(auth)
+		if err := c.ws.writeFrame(context.Background(), MessageText, []byte(pkt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func socketIOEvent(nsp, ackID, event string, args []any) ([]byte, error) {
+	data, err := json.Marshal(append([]any{event}, args...))
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("42"+socketIONamespacePrefix(nsp)+ackID), data...), nil
+}
+
+// socketIONamespacePrefix is the namespace portion of a packet; the main
+// namespace is implicit.
+func socketIONamespacePrefix(nsp string) string {
+	if nsp == "" || nsp == "/" {
+		return ""
+	}
+	return nsp + ","
+}
+
+func socketIOCallID(nsp, ackID string) string {
+	if nsp == "" {
+		nsp = "/"
+	}
+	return "socket.io:" + nsp + ":" + ackID
+}
+
+// engineIOEndpoint adds the engine.io query parameters to endpoint, if missing.
+func engineIOEndpoint(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	q := u.Query()
+	if q.Get("EIO") == "" {
+		q.Set("EIO", "4")
+	}
+	if q.Get("transport") == "" {
+		q.Set("transport", "websocket")
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
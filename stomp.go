@@ -0,0 +1,398 @@
+package apic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// STOMPConfig configures a STOMPClient.
+type STOMPConfig struct {
+	// Host is the virtual host sent in the CONNECT frame.
+	Host string
+
+	// Login and Passcode are sent in the CONNECT frame, if set.
+	Login    string
+	Passcode string
+
+	// Headers are any additional CONNECT frame headers.
+	Headers map[string]string
+
+	// HeartBeatSend is the interval at which the client offers to send heart-beats,
+	// and HeartBeatRecv the interval at which it would like to receive them.
+	// Zero disables either direction. If the server goes quiet for twice the
+	// negotiated receive interval, the connection is forced to reconnect.
+	HeartBeatSend time.Duration
+	HeartBeatRecv time.Duration
+}
+
+// STOMPFrame is a single STOMP frame.
+type STOMPFrame struct {
+	Command string
+	Headers map[string]string
+	Body    []byte
+}
+
+// STOMPError is returned when the server sends an ERROR frame.
+type STOMPError struct {
+	Frame *STOMPFrame
+}
+
+func (e *STOMPError) Error() string {
+	return fmt.Sprintf("stomp error: %s", e.Frame.Headers["message"])
+}
+
+var errSTOMPMalformed = errors.New("stomp: malformed frame")
+
+// STOMPClient is a STOMP 1.2 client running over WSClient, as exposed by the
+// RabbitMQ and ActiveMQ web-stomp plugins. Subscriptions are re-sent after
+// each CONNECTED frame, so they survive reconnects.
+type STOMPClient struct {
+	ws  *WSClient
+	cfg STOMPConfig
+
+	mu        sync.Mutex
+	connected bool
+	seq       uint64
+	subs      map[string]stompSub
+	stop      chan struct{}
+
+	// lastRecv is when a frame, or heart-beat, was last received, in unix nanos
+	lastRecv atomic.Int64
+}
+
+type stompSub struct {
+	destination string
+	ack         string
+	fn          func(*STOMPFrame) error
+}
+
+// NewSTOMPClient creates a STOMP client for the endpoint. The options are passed
+// along to the underlying WSClient, except for the global message handler, which
+// the STOMP client owns.
+func NewSTOMPClient(endpoint string, cfg STOMPConfig, opts ...WSOption) *STOMPClient {
+	c := &STOMPClient{
+		cfg:  cfg,
+		subs: map[string]stompSub{},
+	}
+	opts = append(append([]WSOption{}, opts...), WithWSHandler(c.handle))
+	c.ws = NewWSClient(endpoint, opts...)
+	c.ws.addSubprotocols("v12.stomp", "v11.stomp")
+	c.ws.chainOnOpen(c.onOpen)
+	c.ws.chainOnClose(c.onClose)
+	return c
+}
+
+// WS returns the underlying websocket client.
+func (c *STOMPClient) WS() *WSClient {
+	return c.ws
+}
+
+// Start runs the underlying websocket client. See WSClient.Start.
+func (c *STOMPClient) Start(ctx context.Context) error {
+	return c.ws.Start(ctx)
+}
+
+// Subscribe subscribes to destination with the given ack mode ("auto", "client",
+// or "client-individual"), calling fn with each MESSAGE frame.
+func (c *STOMPClient) Subscribe(ctx context.Context, destination, ack string, fn func(*STOMPFrame) error) (string, error) {
+	if ack == "" {
+		ack = "auto"
+	}
+
+	c.mu.Lock()
+	c.seq++
+	id := "sub-" + strconv.FormatUint(c.seq, 10)
+	sub := stompSub{destination: destination, ack: ack, fn: fn}
+	c.subs[id] = sub
+	connected := c.connected
+	c.mu.Unlock()
+
+	if !connected {
+		return id, nil
+	}
+	return id, c.writeFrame(ctx, sub.frame(id))
+}
+
+// Unsubscribe removes the subscription with the given id.
+func (c *STOMPClient) Unsubscribe(ctx context.Context, id string) error {
+	c.mu.Lock()
+	_, ok := c.subs[id]
+	delete(c.subs, id)
+	connected := c.connected
+	c.mu.Unlock()
+
+	if !ok || !connected {
+		return nil
+	}
+	return c.writeFrame(ctx, &STOMPFrame{Command: "UNSUBSCRIBE", Headers: map[string]string{"id": id}})
+}
+
+// Send sends body to destination. headers may be nil.
+func (c *STOMPClient) Send(ctx context.Context, destination, contentType string, body []byte, headers map[string]string) error {
+	hdrs := map[string]string{}
+	for k, v := range headers {
+		hdrs[k] = v
+	}
+	hdrs["destination"] = destination
+	if contentType != "" {
+		hdrs["content-type"] = contentType
+	}
+	hdrs["content-length"] = strconv.Itoa(len(body))
+	return c.writeFrame(ctx, &STOMPFrame{Command: "SEND", Headers: hdrs, Body: body})
+}
+
+// Ack acknowledges a MESSAGE frame received on a client ack subscription.
+func (c *STOMPClient) Ack(ctx context.Context, msg *STOMPFrame) error {
+	return c.writeFrame(ctx, &STOMPFrame{Command: "ACK", Headers: map[string]string{"id": msg.Headers["ack"]}})
+}
+
+// Nack rejects a MESSAGE frame received on a client ack subscription.
+func (c *STOMPClient) Nack(ctx context.Context, msg *STOMPFrame) error {
+	return c.writeFrame(ctx, &STOMPFrame{Command: "NACK", Headers: map[string]string{"id": msg.Headers["ack"]}})
+}
+
+func (c *STOMPClient) writeFrame(ctx context.Context, f *STOMPFrame) error {
+	return c.ws.writeFrame(ctx, MessageText, f.marshal())
+}
+
+func (c *STOMPClient) onOpen(_ *WSClient) error {
+	hdrs := map[string]string{
+		"accept-version": "1.2,1.1",
+		"heart-beat":     fmt.Sprintf("%d,%d", c.cfg.HeartBeatSend.Milliseconds(), c.cfg.HeartBeatRecv.Milliseconds()),
+	}
+	for k, v := range c.cfg.Headers {
+		hdrs[k] = v
+	}
+	if c.cfg.Host != "" {
+		hdrs["host"] = c.cfg.Host
+	}
+	if c.cfg.Login != "" {
+		hdrs["login"] = c.cfg.Login
+		hdrs["passcode"] = c.cfg.Passcode
+	}
+	return c.writeFrame(context.Background(), &STOMPFrame{Command: "CONNECT", Headers: hdrs})
+}
+
+func (c *STOMPClient) onClose(_ *WSClient) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected = false
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	return nil
+}
+
+func (c *STOMPClient) handle(bts []byte) error {
+	c.lastRecv.Store(c.ws.clock.Now().UnixNano())
+	f, err := parseSTOMPFrame(bts)
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		// heart-beat
+		return nil
+	}
+
+	switch f.Command {
+	case "CONNECTED":
+		return c.onConnected(f)
+	case "MESSAGE":
+		c.mu.Lock()
+		sub, ok := c.subs[f.Headers["subscription"]]
+		c.mu.Unlock()
+		if !ok {
+			c.ws.logger.Debug("stomp: message for unknown subscription", "subscription", f.Headers["subscription"])
+			return nil
+		}
+		return sub.fn(f)
+	case "ERROR":
+		return &STOMPError{Frame: f}
+	case "RECEIPT":
+	default:
+		c.ws.logger.Debug("stomp: unknown frame", "command", f.Command)
+	}
+	return nil
+}
+
+func (c *STOMPClient) onConnected(f *STOMPFrame) error {
+	c.mu.Lock()
+	c.connected = true
+	frames := make([]*STOMPFrame, 0, len(c.subs))
+	for id, sub := range c.subs {
+		frames = append(frames, sub.frame(id))
+	}
+
+	// heart-beats go each way at the slower of what the sender offered and what
+	// the receiver wants
+	var serverSend, serverRecv int64
+	if hb := strings.SplitN(f.Headers["heart-beat"], ",", 2); len(hb) == 2 {
+		serverSend, _ = strconv.ParseInt(hb[0], 10, 64)
+		serverRecv, _ = strconv.ParseInt(hb[1], 10, 64)
+	}
+	send := stompHeartBeat(c.cfg.HeartBeatSend, serverRecv)
+	recv := stompHeartBeat(c.cfg.HeartBeatRecv, serverSend)
+	if send != 0 || recv != 0 {
+		stop := make(chan struct{})
+		c.stop = stop
+		c.lastRecv.Store(c.ws.clock.Now().UnixNano())
+		go c.heartBeat(send, recv, stop)
+	}
+	c.mu.Unlock()
+
+	for _, f := range frames {
+		if err := c.writeFrame(context.Background(), f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stompHeartBeat negotiates a heart-beat interval from the one side's offer and
+// the other's, in milliseconds: zero if either is, otherwise the slower.
+func stompHeartBeat(offer time.Duration, peer int64) time.Duration {
+	p := time.Duration(peer) * time.Millisecond
+	if offer == 0 || p == 0 {
+		return 0
+	}
+	return max(offer, p)
+}
+
+// heartBeat sends heart-beats every send, and checks every recv that the server
+// has sent something within twice recv, forcing a reconnect if not. Either may be
+// zero.
+func (c *STOMPClient) heartBeat(send, recv time.Duration, stop chan struct{}) {
+	var sends, checks <-chan time.Time
+	if send != 0 {
+		t := c.ws.clock.NewTicker(send)
+		defer t.Stop()
+		sends = t.C()
+	}
+	if recv != 0 {
+		t := c.ws.clock.NewTicker(recv)
+		defer t.Stop()
+		checks = t.C()
+	}
+	for {
+		select {
+		case <-sends:
+			if err := c.ws.writeFrame(context.Background(), MessageText, []byte("\n")); err != nil {
+				c.ws.logger.Debug("stomp: heart-beat failed", "error", err.Error())
+			}
+		case now := <-checks:
+			if now.Sub(time.Unix(0, c.lastRecv.Load())) > 2*recv {
+				c.ws.logger.Info("stomp: server heart-beat missed")
+				c.ws.ForceReconnect("stomp heart-beat timeout")
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (s stompSub) frame(id string) *STOMPFrame {
+	return &STOMPFrame{
+		Command: "SUBSCRIBE",
+		Headers: map[string]string{
+			"id":          id,
+			"destination": s.destination,
+			"ack":         s.ack,
+		},
+	}
+}
+
+var stompEscaper = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+
+var stompUnescaper = strings.NewReplacer("\\\\", "\\", "\\r", "\r", "\\n", "\n", "\\c", ":")
+
+// marshal renders the frame in wire format.
+func (f *STOMPFrame) marshal() []byte {
+	escape := f.Command != "CONNECT" && f.Command != "CONNECTED"
+
+	var buf bytes.Buffer
+	buf.WriteString(f.Command)
+	buf.WriteByte('\n')
+	for k, v := range f.Headers {
+		if escape {
+			k, v = stompEscaper.Replace(k), stompEscaper.Replace(v)
+		}
+		buf.WriteString(k)
+		buf.WriteByte(':')
+		buf.WriteString(v)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	buf.Write(f.Body)
+	buf.WriteByte(0)
+	return buf.Bytes()
+}
+
+// parseSTOMPFrame parses a single frame. A nil frame and error means the
+// message was a heart-beat.
+func parseSTOMPFrame(bts []byte) (*STOMPFrame, error) {
+	bts = bytes.TrimLeft(bts, "\r\n")
+	if len(bts) == 0 {
+		return nil, nil
+	}
+
+	line := func() (string, bool) {
+		i := bytes.IndexByte(bts, '\n')
+		if i < 0 {
+			return "", false
+		}
+		l := bts[:i]
+		bts = bts[i+1:]
+		return string(bytes.TrimSuffix(l, []byte("\r"))), true
+	}
+
+	cmd, ok := line()
+	if !ok {
+		return nil, errSTOMPMalformed
+	}
+	f := &STOMPFrame{Command: cmd, Headers: map[string]string{}}
+	unescape := cmd != "CONNECTED"
+
+	for {
+		l, ok := line()
+		if !ok {
+			return nil, errSTOMPMalformed
+		}
+		if l == "" {
+			break
+		}
+		k, v, ok := strings.Cut(l, ":")
+		if !ok {
+			return nil, errSTOMPMalformed
+		}
+		if unescape {
+			k, v = stompUnescaper.Replace(k), stompUnescaper.Replace(v)
+		}
+		// repeated headers: only the first is used
+		if _, ok := f.Headers[k]; !ok {
+			f.Headers[k] = v
+		}
+	}
+
+	if cl, ok := f.Headers["content-length"]; ok {
+		n, err := strconv.Atoi(cl)
+		if err != nil || n < 0 || n > len(bts) {
+			return nil, errSTOMPMalformed
+		}
+		f.Body = bts[:n]
+		return f, nil
+	}
+	if i := bytes.IndexByte(bts, 0); i >= 0 {
+		bts = bts[:i]
+	}
+	f.Body = bts
+	return f, nil
+}
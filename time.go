@@ -0,0 +1,146 @@
+package apic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// UnixTime, UnixMilliTime and LocalTime are time.Time fields in the encodings
+// apis commonly use instead of RFC3339. Each encodes itself, so they work with
+// every codec, and in form bodies, without registering anything: UnixTime as
+// epoch seconds, UnixMilliTime as epoch milliseconds, and LocalTime as RFC3339
+// without a zone, read as UTC. Epochs decode from numbers or quoted numbers,
+// fractions and exponents included, and null or 0 decodes to the zero time.
+type (
+	UnixTime      struct{ time.Time }
+	UnixMilliTime struct{ time.Time }
+	LocalTime     struct{ time.Time }
+)
+
+// LocalTimeLayout is the layout of a LocalTime.
+const LocalTimeLayout = "2006-01-02T15:04:05.999999999"
+
+func (t UnixTime) MarshalJSON() ([]byte, error) {
+	return t.MarshalText()
+}
+
+func (t UnixTime) MarshalText() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("0"), nil
+	}
+	return strconv.AppendInt(nil, t.Unix(), 10), nil
+}
+
+func (t *UnixTime) UnmarshalJSON(bts []byte) error {
+	return t.UnmarshalText(bts)
+}
+
+func (t *UnixTime) UnmarshalText(text []byte) error {
+	parsed, err := epoch(text, time.Second)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+func (t UnixMilliTime) MarshalJSON() ([]byte, error) {
+	return t.MarshalText()
+}
+
+func (t UnixMilliTime) MarshalText() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("0"), nil
+	}
+	return strconv.AppendInt(nil, t.UnixMilli(), 10), nil
+}
+
+func (t *UnixMilliTime) UnmarshalJSON(bts []byte) error {
+	return t.UnmarshalText(bts)
+}
+
+func (t *UnixMilliTime) UnmarshalText(text []byte) error {
+	parsed, err := epoch(text, time.Millisecond)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+func (t LocalTime) MarshalJSON() ([]byte, error) {
+	text, _ := t.MarshalText()
+	return json.Marshal(string(text))
+}
+
+func (t LocalTime) MarshalText() ([]byte, error) {
+	return []byte(t.UTC().Format(LocalTimeLayout)), nil
+}
+
+func (t *LocalTime) UnmarshalJSON(bts []byte) error {
+	if bytes.Equal(bts, []byte("null")) {
+		t.Time = time.Time{}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(bts, &s); err != nil {
+		return err
+	}
+	return t.UnmarshalText([]byte(s))
+}
+
+func (t *LocalTime) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		t.Time = time.Time{}
+		return nil
+	}
+	parsed, err := time.ParseInLocation(LocalTimeLayout, string(text), time.UTC)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+// epoch parses an epoch of unit, a number or a quoted number, as json or text.
+// null, the empty string, and 0, which the zero time encodes as, parse as the zero
+// time. Fractional and exponent forms are allowed, to the nanosecond.
+func epoch(bts []byte, unit time.Duration) (time.Time, error) {
+	if bytes.Equal(bts, []byte("null")) {
+		return time.Time{}, nil
+	}
+	if len(bts) > 0 && bts[0] == '"' {
+		var s string
+		if err := json.Unmarshal(bts, &s); err != nil {
+			return time.Time{}, err
+		}
+		bts = []byte(s)
+	}
+	if len(bts) == 0 {
+		return time.Time{}, nil
+	}
+
+	if n, err := strconv.ParseInt(string(bts), 10, 64); err == nil {
+		switch {
+		case n == 0:
+			return time.Time{}, nil
+		case unit == time.Second:
+			return time.Unix(n, 0).UTC(), nil
+		default:
+			return time.UnixMilli(n).UTC(), nil
+		}
+	}
+	f, err := strconv.ParseFloat(string(bts), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return time.Time{}, fmt.Errorf("invalid epoch %q", bts)
+	}
+	if f == 0 {
+		return time.Time{}, nil
+	}
+	sec, frac := math.Modf(f * unit.Seconds())
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+}
@@ -4,8 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"golang.org/x/time/rate"
 	"nhooyr.io/websocket"
 )
 
@@ -13,14 +17,92 @@ type WSClient struct {
 	// endpoint is the server endpoint
 	endpoint string
 
-	// logger infos connection lifecycles, and debugs each message sent and received
-	logger Logger
+	// endpoints, if set, picks among several endpoints for each dial. current
+	// is the endpoint of the latest connection, and a connection to a backup
+	// endpoint is checked every failbackInterval for the primary's recovery.
+	endpoints        endpointStrategy
+	current          string
+	failbackInterval time.Duration
 
-	// conn is the (current) underlying connection
-	conn *websocket.Conn
+	// override, once set by SetEndpoint, is dialed in place of the above
+	override string
 
-	// handler is the global message handler
-	handler func([]byte) error
+	// logger infos connection lifecycles, and debugs each message sent and received,
+	// with its body, passed through logRedactor, only if logBodies is set
+	logger      Logger
+	logBodies   bool
+	logRedactor func([]byte) []byte
+
+	// chaos, if set, injects faults
+	chaos *ChaosConfig
+
+	// recorder, if set, captures inbound frames, as does journal, durably
+	recorder *recorder
+	journal  *journal
+
+	// replay, if set, is replayed in place of connecting
+	replay *replaySource
+
+	// clock times stale detection, pings, heartbeats, backoff, and the rest
+	clock Clock
+
+	// stats backs Stats
+	stats wsStats
+
+	// metrics is sent counters and gauges for the connection
+	metrics WSMetrics
+
+	// tracer, if set, traces connections, and traceSampleRate of messages
+	tracer          WSTracer
+	traceSampleRate float64
+
+	// conn is the (current) underlying connection, and writer the goroutine
+	// every message is written to it by. mu guards them, and the offline write
+	// queue, against writers on other goroutines
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	writer *connWriter
+
+	// forced is signaled to have the current connection reconnect, and
+	// migrate to have it move to a new endpoint set with SetEndpoint
+	forced  chan string
+	migrate chan struct{}
+
+	// state is the connection state, with stateSubs subscribed to changes.
+	// ready is closed while the state is StateConnected.
+	state     ConnState
+	stateSubs map[chan ConnState]struct{}
+	ready     chan struct{}
+
+	// draining is set once Drain is called, and drainer stops the client
+	draining bool
+	drainer  *drainer
+
+	// closeStatus, if set, is the close frame sent when the client is stopped
+	closeStatus *closeStatus
+
+	// lastClose is the server's close frame that ended the latest connection
+	lastClose *CloseError
+
+	// messageSubs are the Messages consumers
+	messageSubs map[*messageSub]struct{}
+
+	// events, once requested, receives lifecycle events
+	events chan WSEvent
+
+	// queue holds messages written while disconnected, if enabled
+	queue *writeQueue
+
+	// handler is the global message handler, and filter, if set, decides which
+	// messages reach it
+	handler func(MessageType, []byte) error
+	filter  func([]byte) bool
+
+	// inbound and outbound are the message middleware, outermost first, and
+	// outboundFn is transmit wrapped in the outbound middleware
+	inbound    []InboundMiddleware
+	outbound   []OutboundMiddleware
+	outboundFn OutboundFunc
 
 	// onOpen is the callback invoked after each connection is opened
 	onOpen func(*WSClient) error
@@ -28,33 +110,161 @@ type WSClient struct {
 	// onClose is the callback invoked after each connection is closed
 	onClose func(*WSClient) error
 
+	// onError is the callback invoked for each error the client encounters
+	onError func(err error, fatal bool)
+
 	// dialOptionsFunc is called ahead of dialing each new connection
 	dialOptionsFunc func() (*websocket.DialOptions, error)
 
+	// token, if set, authorizes each dial
+	token *wsToken
+
+	// sessionResume, if set, resumes the session on each redial, once dialed
+	// is set by the first successful dial
+	sessionResume *sessionResume
+	dialed        bool
+
+	// transport, if set, customizes the handshake's http transport
+	transport *transportConfig
+
+	// compression, if set, overrides the dial options' compression settings
+	compression *compressionConfig
+
+	// subprotocols are requested in addition to any from the dial options,
+	// and subprotocol is the one the server accepted on the current connection
+	subprotocols []string
+	subprotocol  string
+
 	encoder Encoder
+	decoder Decoder
+
+	// messageType is the frame type Write and Send use
+	messageType MessageType
+
+	// writeLimiter, if set, limits the rate of writes, except for those
+	// writeLimits classifies in to a class with a limiter of its own
+	writeLimiter *writeGate
+	writeLimits  *writeLimits
+
+	// writeBytes, if set, limits the byte rate of writes
+	writeBytes *rate.Limiter
 
+	// writeTimeout, if set, bounds how long each write may take
+	writeTimeout time.Duration
+
+	// inboundBuffer and inboundPolicy control backpressure between
+	// the reader and the handler
+	inboundBuffer int
+	inboundPolicy InboundPolicy
+	dropped       atomic.Uint64
+
+	// outbox, if set, durably holds messages written while disconnected, and
+	// with outboxAcks, those written while connected until acknowledged
+	outbox     *Outbox
+	outboxAcks *outboxAcks
+
+	// pause holds back inbound messages from the handler while paused
+	pause *pauser
+
+	// readThrottle, if set, limits how fast messages are read
+	readThrottle *readThrottle
+
+	// handlerConcurrency, if set, is the number of handler workers, and
+	// orderingKey picks the worker for each message
+	handlerConcurrency int
+	orderingKey        func([]byte) string
+
+	// acks, if set, acknowledges handled messages
+	acks *acks
+
+	// dedup, if set, drops messages already seen within its window
+	dedup *dedupWindow
+
+	// sequence, if set, checks inbound messages for sequence gaps
+	sequence *sequenceTracker
+
+	// disablePanicRecovery lets handler panics crash the process
+	disablePanicRecovery bool
+
+	// batcher, if set, coalesces messages from Write and Send in to batches
+	batcher     *writeBatcher
+	batchFormat BatchFormat
+
+	// pingInterval, if set, is how often the connection is pinged. lastRTT
+	// is the latest ping round trip time, also passed to onRTT.
 	pingInterval time.Duration
+	lastRTT      atomic.Int64
+	onRTT        func(time.Duration)
+
+	// heartbeat, if set, is the application level heartbeat
+	heartbeat *heartbeat
+
+	reconnectPolicy ReconnectPolicy
 
-	shouldReconnect reconnectPolicy
+	// maxReconnectDuration, if set, is how long the client keeps trying
+	// to reconnect before giving up
+	maxReconnectDuration time.Duration
 
+	// maxAttempts, if set, is how many reconnect attempts the client makes
+	// before giving up
+	maxAttempts int
+
+	// onReconnect is the callback invoked before each reconnect attempt
+	onReconnect func(attempt int, lastErr error, downtime time.Duration)
+
+	// attempt counts reconnect attempts since the last successful connect,
+	// and downSince is when the client was last disconnected. Both are reset
+	// once a connection has been up for attemptResetAfter.
+	attempt           int
+	downSince         time.Time
+	attemptResetAfter time.Duration
+
+	// staleMessageTimeout, if set, is how long the connection may go without
+	// signs of life, as judged by staleMode
 	staleMessageTimeout time.Duration
+	staleMode           StaleMode
+
+	// staleClasses, if set, gives classes of messages timeouts of their own
+	staleClasses *staleClasses
+
+	// callAttach and callExtract correlate Call requests with their responses
+	callAttach  CallIDAttacher
+	callExtract CallIDExtractor
+	callTimeout time.Duration
+	callSeq     atomic.Uint64
+	callsMu     sync.Mutex
+	calls       map[string]chan []byte
 }
 
 func NewWSClient(endpoint string, opts ...WSOption) *WSClient {
 	w := &WSClient{
-		logger:          noLogger{},
-		endpoint:        endpoint,
-		encoder:         defaultEncoder,
-		handler:         func(_ []byte) error { return nil },
-		onOpen:          func(_ *WSClient) error { return nil },
-		onClose:         func(_ *WSClient) error { return nil },
-		shouldReconnect: func(_ error) bool { return false },
-		dialOptionsFunc: func() (*websocket.DialOptions, error) { return nil, nil },
+		logger:           noLogger{},
+		metrics:          noMetrics{},
+		pause:            newPauser(),
+		clock:            realClock{},
+		ready:            make(chan struct{}),
+		drainer:          newDrainer(),
+		endpoint:         endpoint,
+		failbackInterval: 30 * time.Second,
+		encoder:          defaultEncoder,
+		decoder:          defaultDecoder,
+		messageType:      MessageText,
+		handler:          func(_ MessageType, _ []byte) error { return nil },
+		onOpen:           func(_ *WSClient) error { return nil },
+		onClose:          func(_ *WSClient) error { return nil },
+		onError:          func(_ error, _ bool) {},
+		onReconnect:      func(_ int, _ error, _ time.Duration) {},
+		onRTT:            func(_ time.Duration) {},
+		reconnectPolicy:  ReconnectFunc(func(_ error, _ int, _ time.Duration) (bool, time.Duration) { return false, 0 }),
+		dialOptionsFunc:  func() (*websocket.DialOptions, error) { return nil, nil },
+		callAttach:       attachJSONID,
+		callExtract:      extractJSONID,
 	}
 
 	for _, opt := range opts {
 		opt(w)
 	}
+	w.buildOutbound()
 
 	return w
 }
@@ -62,163 +272,680 @@ func NewWSClient(endpoint string, opts ...WSOption) *WSClient {
 // Start runs the client until either:
 // - the context is canceled
 // - the reconnect policy returns false
+// - the client is stopped with Drain or CloseWithStatus
+//
+// A stopped client may be started again.
 func (c *WSClient) Start(ctx context.Context) error {
+	d := c.resetDrainer()
+	if c.replay != nil {
+		return c.startReplay(ctx, d)
+	}
+	defer d.finished()
+	defer c.setState(StateClosed)
 	for {
+		if c.attempt == 0 {
+			c.setState(StateConnecting)
+		} else {
+			c.setState(StateReconnecting)
+		}
 		err := c.run(ctx)
 		c.logger.Info("disconnected", "error", err)
-		if !c.shouldReconnect(err) {
+		// a deliberate stop isn't a failure: nothing is counted toward reconnects
+		if errors.Is(err, errDrained) || d.stopped() || ctx.Err() != nil {
+			c.emit(DisconnectedEvent{})
+			return nil
+		}
+		c.emit(DisconnectedEvent{Err: err})
+		if errors.Is(err, errFailback) {
+			c.logger.Info("failing back to primary endpoint")
+			continue
+		}
+		if errors.Is(err, errMigrate) {
+			c.logger.Info("moving to new endpoint", "endpoint", c.nextEndpoint())
+			continue
+		}
+		if err != nil {
+			c.onError(err, true)
+		}
+		if c.downSince.IsZero() {
+			c.downSince = c.clock.Now()
+		}
+		elapsed := c.clock.Now().Sub(c.downSince)
+		if c.maxReconnectDuration != 0 && elapsed > c.maxReconnectDuration {
+			return &ReconnectDurationError{Elapsed: elapsed, Err: err}
+		}
+		c.attempt++
+		if c.maxAttempts != 0 && c.attempt > c.maxAttempts {
+			return &MaxAttemptsError{Attempts: c.attempt - 1, Err: err}
+		}
+		c.metrics.Reconnect(c.Endpoint())
+		c.stats.reconnects.Add(1)
+		c.stats.attempt.Store(int64(c.attempt))
+		retry, delay := c.reconnectPolicy.Reconnect(err, c.attempt, elapsed)
+		if !retry {
 			return err
 		}
-		c.logger.Info("reconnecting...")
+		c.logger.Info("reconnect backoff", "duration", delay.String())
+		c.emit(ReconnectScheduledEvent{Attempt: c.attempt, Delay: delay})
+		if !c.wait(ctx, delay) {
+			return nil
+		}
+		c.onReconnect(c.attempt, err, c.clock.Now().Sub(c.downSince))
+		c.logger.Info("reconnecting...", "attempt", c.attempt)
 	}
 }
 
-var ErrNotConnected = errors.New("websocket not connected")
+var (
+	ErrNotConnected    = errors.New("websocket not connected")
+	ErrStaleConnection = errors.New("websocket connection stale")
+)
+
+// MessageType is the type of a websocket data frame.
+type MessageType = websocket.MessageType
+
+const (
+	MessageText   = websocket.MessageText
+	MessageBinary = websocket.MessageBinary
+)
 
 // Write encodes and writes an object to the current connection.
 func (c *WSClient) Write(ctx context.Context, obj any) error {
-	if c.conn == nil {
-		return ErrNotConnected
+	bts, err := c.encoder(obj)
+	if err != nil {
+		return err
 	}
+	return c.Send(ctx, bts)
+}
 
-	bts, err := c.encoder(obj)
+// Decode decodes a received message in to obj, with the client's decoder.
+func (c *WSClient) Decode(bts []byte, obj any) error {
+	return c.decoder(bts, obj)
+}
+
+// Send writes already encoded bytes to the current connection, using the
+// configured message type.
+func (c *WSClient) Send(ctx context.Context, bts []byte) error {
+	// binary messages can't be joined in to a json array
+	if c.batcher != nil && !(c.messageType == MessageBinary && c.batchFormat == BatchArray) {
+		if c.isDraining() {
+			return ErrDraining
+		}
+		return c.batchWrite(ctx, bts)
+	}
+	return c.writeFrame(ctx, c.messageType, bts)
+}
+
+// SendBinary writes bytes to the current connection as a binary message.
+func (c *WSClient) SendBinary(ctx context.Context, bts []byte) error {
+	return c.writeFrame(ctx, MessageBinary, bts)
+}
+
+// writeFrame writes an already encoded message to the current connection,
+// unless the client is draining.
+func (c *WSClient) writeFrame(ctx context.Context, typ MessageType, bts []byte) error {
+	if c.isDraining() {
+		return ErrDraining
+	}
+	return c.send(ctx, typ, bts)
+}
+
+// transmit writes an already encoded message to the current connection,
+// or queues it while disconnected.
+func (c *WSClient) transmit(ctx context.Context, typ MessageType, bts []byte) error {
+	if err := c.waitWrite(ctx, bts); err != nil {
+		return err
+	}
+
+	if c.replay != nil {
+		c.logMessage("discard", typ, bts)
+		return nil
+	}
+
+	c.mu.Lock()
+	w := c.writer
+	c.mu.Unlock()
+	msg := wsMessage{typ: typ, bts: bts}
+	if stored, err := c.store(msg, w != nil); stored || err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	w = c.writer
+	if w == nil || (c.queue != nil && c.queue.flushing) {
+		defer c.mu.Unlock()
+		if c.queue == nil {
+			return ErrNotConnected
+		}
+		c.logMessage("queue", typ, bts)
+		err := c.queue.push(msg)
+		c.metrics.QueueDepth(c.endpointLocked(), len(c.queue.messages))
+		return err
+	}
+	c.mu.Unlock()
+
+	e, err := c.track(msg)
 	if err != nil {
 		return err
 	}
-	c.logger.Debug("send", "message", string(bts))
+	err = w.write(ctx, typ, bts)
+	abandoned := ctx != nil && ctx.Err() != nil
+	if e != nil {
+		if kept, serr := c.wrote(e, err, abandoned); kept || serr != nil {
+			return serr
+		}
+		return err
+	}
+	if err != nil && !abandoned {
+		// the connection failed under the write; keep it for the next one
+		if stored, serr := c.store(msg, false); stored {
+			return serr
+		}
+	}
+	return err
+}
+
+// writeConn writes a message to conn, applying the write timeout.
+func (c *WSClient) writeConn(ctx context.Context, conn *websocket.Conn, typ MessageType, bts []byte) (err error) {
+	c.logMessage("send", typ, bts)
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	return c.conn.Write(ctx, websocket.MessageText, bts)
+	if c.sampleMessage() {
+		var span WSSpan
+		ctx, span = c.startSpan(ctx, "ws.send", WSAttr{Key: "ws.message_size", Value: len(bts)})
+		defer func() { endSpan(span, err) }()
+	}
+	if c.writeTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.writeTimeout)
+		defer cancel()
+	}
+	if err := conn.Write(ctx, typ, bts); err != nil {
+		return err
+	}
+	c.metrics.MessageSent(c.Endpoint(), len(bts))
+	c.stats.sent(len(bts))
+	return nil
+}
+
+// Connected returns a channel that is closed once the client is connected, and
+// the onOpen callback has returned. A new channel is used for each connection,
+// so callers should call Connected again after a disconnect.
+func (c *WSClient) Connected() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ready
+}
+
+// WaitForConnection blocks until the client is connected (see Connected), or
+// ctx is done.
+func (c *WSClient) WaitForConnection(ctx context.Context) error {
+	select {
+	case <-c.Connected():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Subprotocol returns the subprotocol the server accepted for the current
+// connection, or "" if none was negotiated.
+func (c *WSClient) Subprotocol() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.subprotocol
+}
+
+// ForceReconnect closes the current connection with reason, and reconnects as it
+// would after any other disconnect, ie, when the application knows the session
+// is bad though the connection is alive. It is a no-op while disconnected.
+func (c *WSClient) ForceReconnect(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.forced == nil {
+		return
+	}
+	select {
+	case c.forced <- reason:
+	default:
+	}
+}
+
+// Conn returns the current underlying websocket connection, or ErrNotConnected.
+// It is replaced on every reconnect, so it shouldn't be held on to past a
+// disconnect.
+//
+// The client's read loop owns reading from the connection, so callers must not
+// read from it. Writes are safe alongside the client's own, as are pings. Closing
+// it has the client reconnect, as for any other disconnect.
+func (c *WSClient) Conn() (*websocket.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil, ErrNotConnected
+	}
+	return c.conn, nil
 }
 
 // run connects the websocket, and runs the single connection until
 // either the connection is terminated, or the global handler returns
 // a non nil error.
-func (c *WSClient) run(ctx context.Context) error {
+func (c *WSClient) run(ctx context.Context) (err error) {
 	if err := c.connect(ctx); err != nil {
 		return err
 	}
-	connectedAt := time.Now()
+	drain := c.currentDrainer().drain
+	_, session := c.startSpan(ctx, "ws.session", WSAttr{Key: "ws.subprotocol", Value: c.Subprotocol()})
+	defer func() {
+		session.SetAttributes(closeCodeAttr(err))
+		endSpan(session, err)
+	}()
+	connectedAt := c.clock.Now()
 	c.logger.Info("connected")
-	defer c.conn.Close(websocket.StatusInternalError, "app closing")
 
-	readErr := make(chan error)
-	data := make(chan []byte)
-	go reader(c.conn, data, readErr)
+	// the attempt counter is reset once the connection has proven stable
+	var stable <-chan time.Time
+	if c.attemptResetAfter == 0 {
+		c.resetAttempts()
+	} else {
+		t := c.clock.NewTimer(c.attemptResetAfter)
+		defer t.Stop()
+		stable = t.C()
+	}
+	defer func() {
+		c.mu.Lock()
+		conn := c.conn
+		c.conn = nil
+		c.writer.stop()
+		c.writer = nil
+		c.forced = nil
+		c.migrate = nil
+		c.setStateLocked(StateDisconnected)
+		c.mu.Unlock()
+		c.closeConn(conn)
+	}()
+
+	c.mu.Lock()
+	forced, migrate := c.forced, c.migrate
+	c.mu.Unlock()
+
+	readErr := make(chan error, 1)
+	data := make(chan wsMessage, c.inboundBuffer)
+	readDone := make(chan struct{})
+	defer close(readDone)
+	go c.reader(c.conn, data, readErr, readDone)
 
 	if err := c.onOpen(c); err != nil {
 		return err
 	}
+	if err := c.flushQueue(ctx); err != nil {
+		return err
+	}
+	if err := c.flushOutbox(c.writer); err != nil {
+		return err
+	}
+	c.setState(StateConnected)
+	c.emit(ConnectedEvent{Endpoint: c.Endpoint()})
 	defer func() {
 		if err := c.onClose(c); err != nil {
 			c.logger.Info("onClose returned error", "error", err.Error())
+			c.onError(err, false)
 		}
 	}()
 
 	c.logger.Info("starting")
 
 	staleCheck := time.Second * 60
-	if c.staleMessageTimeout != 0 {
+	if c.staleMessageTimeout != 0 || c.staleClasses != nil {
 		staleCheck = time.Second
 	}
-	staleTicker := time.NewTicker(staleCheck)
+	liveness := c.newClassLiveness(connectedAt)
+	staleTicker := c.clock.NewTicker(staleCheck)
 	defer staleTicker.Stop()
 
-	pings := make(chan struct{})
+	// pings run off the loop, since the pong is only read while the loop
+	// keeps the reader moving; at most one is in flight at a time
+	var pings <-chan time.Time
 	if c.pingInterval != 0 {
-		go func() {
-			t := time.NewTicker(c.pingInterval)
-			defer t.Stop()
-			for {
-				defer close(pings)
-				<-t.C
-				select {
-				case pings <- struct{}{}:
-				default:
-					return
-				}
-			}
-		}()
+		t := c.clock.NewTicker(c.pingInterval)
+		defer t.Stop()
+		pings = t.C()
+	}
+	pongs := make(chan pingResult, 1)
+	pinging := false
+
+	var pool *handlerPool
+	if c.handlerConcurrency > 0 {
+		pool = c.startHandlerPool()
+		defer pool.stop()
 	}
 
+	receive := c.inboundHandler(pool)
+
+	hb := c.startHeartbeat()
+	defer hb.stop()
+
+	failback, stopProbe := c.failbackProbe(c.Endpoint())
+	defer stopProbe()
+
 	var lastMessageTimestamp time.Time
 
 	for {
 		select {
-		case bts := <-data:
-			c.logger.Debug("recv", "message", string(bts))
-			lastMessageTimestamp = time.Now()
-			if err := c.handler(bts); err != nil {
+		case msg, ok := <-data:
+			if !ok {
+				// the reader closes data once it has sent its error
+				return c.readError(<-readErr)
+			}
+			c.logMessage("recv", msg.typ, msg.bts)
+			lastMessageTimestamp = c.clock.Now()
+			liveness.received(msg.bts, lastMessageTimestamp)
+			c.metrics.MessageReceived(c.Endpoint(), len(msg.bts))
+			c.stats.received(len(msg.bts), c.clock.Now())
+			c.record(msg)
+			if err := c.journalFrame(msg); err != nil {
 				return err
 			}
-		case <-staleTicker.C:
+			if hb.reply(msg.bts) {
+				continue
+			}
+			copies, err := c.chaos.inbound(c)
+			if err != nil {
+				return err
+			}
+			for i := 0; i < copies; i++ {
+				if err := c.traceReceive(ctx, msg, receive); err != nil {
+					return err
+				}
+			}
+		case <-hb.tick():
+			if err := hb.send(ctx, c, c.writer); err != nil {
+				return err
+			}
+		case <-hb.missed():
+			return ErrHeartbeatTimeout
+		case <-c.pause.resumed:
+			if err := c.deliverHeld(pool); err != nil {
+				return err
+			}
+		case <-failback:
+			return errFailback
+		case reason := <-forced:
+			c.logger.Info("forcing reconnect", "reason", reason)
+			if err := c.conn.Close(websocket.StatusGoingAway, reason); err != nil {
+				c.logger.Debug("failed to close connection for forced reconnect", "err", err.Error())
+			}
+			return &ForcedReconnectError{Reason: reason}
+		case <-migrate:
+			if err := c.conn.Close(websocket.StatusGoingAway, "moving endpoint"); err != nil {
+				c.logger.Debug("failed to close connection for endpoint move", "err", err.Error())
+			}
+			return errMigrate
+		case err := <-pool.errors():
+			return err
+		case <-stable:
+			c.resetAttempts()
+		case <-staleTicker.C():
 			c.logger.Debug("checking timeout", "connected_at", connectedAt)
+			if class, last, ok := liveness.stale(c.clock.Now()); ok {
+				c.logger.Debug("message class appears stale!", "class", class, "last_message_time", last.Format(time.RFC3339))
+				c.emit(StaleDetectedEvent{LastMessage: last, Class: class})
+				c.closeStale()
+				return ErrStaleConnection
+			}
 			if c.staleMessageTimeout == 0 {
 				c.logger.Debug("no timeout configured")
 				continue
 			}
 			// Just connected, let the connection ride for a minute before asserting
-			if lastMessageTimestamp.IsZero() && connectedAt.After(time.Now().Add(time.Minute*-1)) {
+			if lastMessageTimestamp.IsZero() && connectedAt.After(c.clock.Now().Add(time.Minute*-1)) {
 				c.logger.Debug("no message yet received")
 				continue
 			}
 
-			check := time.Now().Add(-1 * c.staleMessageTimeout)
+			check := c.clock.Now().Add(-1 * c.staleMessageTimeout)
 			if lastMessageTimestamp.Before(check) {
 				c.logger.Debug("connection appears stale!", "last_message_time", lastMessageTimestamp.Format(time.RFC3339))
-				if err := c.conn.Close(websocket.StatusGoingAway, "we think this connection has died"); err != nil {
-					c.logger.Debug("failed to close apparent stale connection", "err", err.Error())
-				}
-				staleTicker.Stop()
+				c.emit(StaleDetectedEvent{LastMessage: lastMessageTimestamp})
+				c.closeStale()
+				return ErrStaleConnection
 			} else {
 				c.logger.Debug("connection seems healthy")
 			}
 		case err := <-readErr:
-			return err
+			return c.readError(err)
 		case <-pings:
-			if err := c.conn.Ping(ctx); err != nil {
-				return err
+			if pinging {
+				continue
 			}
+			pinging = true
+			go c.ping(ctx, c.conn, pongs)
+		case res := <-pongs:
+			pinging = false
+			if res.err != nil {
+				return res.err
+			}
+			if c.staleMode == StaleOnPong {
+				lastMessageTimestamp = c.clock.Now()
+			}
+			c.lastRTT.Store(int64(res.rtt))
+			c.onRTT(res.rtt)
+		case <-drain:
+			return errDrained
 		case <-ctx.Done():
 			return nil
 		}
 	}
 }
 
+// closeStale closes a connection stale detection has given up on.
+func (c *WSClient) closeStale() {
+	if err := c.conn.Close(websocket.StatusGoingAway, "we think this connection has died"); err != nil {
+		c.logger.Debug("failed to close apparent stale connection", "err", err.Error())
+		c.onError(err, false)
+	}
+}
+
+// pingResult is the outcome of a single ping.
+type pingResult struct {
+	rtt time.Duration
+	err error
+}
+
+// ping pings conn, reporting the round trip time on results. The pong is due
+// before the next ping would be sent.
+func (c *WSClient) ping(ctx context.Context, conn *websocket.Conn, results chan<- pingResult) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	t := c.clock.NewTimer(c.pingInterval)
+	defer t.Stop()
+
+	start := c.clock.Now()
+	pong := make(chan error, 1)
+	go func() { pong <- conn.Ping(ctx) }()
+	var err error
+	select {
+	case err = <-pong:
+	case <-t.C():
+		cancel()
+		<-pong
+		err = ErrPongTimeout
+	}
+	results <- pingResult{rtt: c.clock.Now().Sub(start), err: err}
+}
+
+// LastRTT returns the round trip time of the most recent ping, or zero if
+// pings are disabled or none has completed yet.
+func (c *WSClient) LastRTT() time.Duration {
+	return time.Duration(c.lastRTT.Load())
+}
+
+// resetAttempts marks the client as recovered, after a successful connection.
+func (c *WSClient) resetAttempts() {
+	c.attempt = 0
+	c.downSince = time.Time{}
+	c.stats.attempt.Store(0)
+}
+
+// traceReceive receives msg, in a span if it is sampled.
+func (c *WSClient) traceReceive(ctx context.Context, msg wsMessage, receive InboundFunc) error {
+	if !c.sampleMessage() {
+		return receive(msg.typ, msg.bts)
+	}
+	_, span := c.startSpan(ctx, "ws.receive", WSAttr{Key: "ws.message_size", Value: len(msg.bts)})
+	err := receive(msg.typ, msg.bts)
+	endSpan(span, err)
+	return err
+}
+
+// receive runs an inbound message through the client, handing it to
+// the handler pool if there is one, otherwise to the handler directly.
+func (c *WSClient) receive(msg wsMessage, pool *handlerPool) error {
+	c.ackOutbox(msg.bts)
+	if c.resolveCall(msg.bts) {
+		return nil
+	}
+	if c.dedup != nil && c.dedup.duplicate(msg.bts, c.clock.Now()) {
+		c.logger.Debug("dropping duplicate message")
+		return nil
+	}
+	if c.sequence != nil {
+		if err := c.sequence.check(msg.bts); err != nil {
+			return err
+		}
+	}
+	if c.filter != nil && !c.filter(msg.bts) {
+		return nil
+	}
+	c.publish(msg)
+	if c.hold(msg) {
+		return nil
+	}
+	if pool != nil {
+		pool.dispatch(msg)
+		return nil
+	}
+	return c.deliver(msg)
+}
+
 // connect creates a new connection and assigns it
 // to the receiver
 // TODO: the threadsafety thing, across the whole client
-func (c *WSClient) connect(ctx context.Context) error {
-	opts, err := c.dialOptionsFunc()
+func (c *WSClient) connect(ctx context.Context) (err error) {
+	ctx, span := c.startSpan(ctx, "ws.dial")
+	defer func() { endSpan(span, err) }()
+
+	opts, err := c.dialOptions()
 	if err != nil {
 		return fmt.Errorf("dial options: %w", err)
 	}
 
-	conn, _, err := websocket.Dial(ctx, c.endpoint, opts)
+	if err := c.chaos.handshake(); err != nil {
+		return err
+	}
+
+	endpoint := c.nextEndpoint()
+	span.SetAttributes(WSAttr{Key: "ws.endpoint", Value: endpoint})
+	dialEndpoint, err := c.authorize(ctx, endpoint, opts)
+	if err != nil {
+		return err
+	}
+	dialEndpoint = c.resume(dialEndpoint, opts)
+
+	conn, _, err := websocket.Dial(ctx, dialEndpoint, opts)
+	if c.endpoints != nil {
+		c.endpoints.report(endpoint, err, c.clock.Now())
+	}
 	if err != nil {
 		return err
 	}
 	conn.SetReadLimit(-1) // that's just like, my opinion or whatever
+	c.mu.Lock()
 	c.conn = conn
+	c.writer = c.startWriter(conn)
+	c.forced = make(chan string, 1)
+	c.migrate = make(chan struct{}, 1)
+	c.current = endpoint
+	c.lastClose = nil
+	c.subprotocol = conn.Subprotocol()
+	c.mu.Unlock()
+	c.dialed = true
+	c.stats.connected(c.clock.Now())
+	span.SetAttributes(WSAttr{Key: "ws.subprotocol", Value: conn.Subprotocol()})
 	return nil
 }
 
-// reader is a helper func to pump messages from a connection
-func reader(conn *websocket.Conn, data chan []byte, errs chan error) {
+// wsMessage is a single message read from a connection
+type wsMessage struct {
+	typ MessageType
+	bts []byte
+}
+
+// dialOptions builds the options for dialing a new connection, layering
+// the client's own settings over the caller's dial options.
+func (c *WSClient) dialOptions() (*websocket.DialOptions, error) {
+	opts, err := c.dialOptionsFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	var o websocket.DialOptions
+	if opts != nil {
+		o = *opts
+	}
+	// headers are copied, so they can be added to per dial
+	o.HTTPHeader = o.HTTPHeader.Clone()
+	if o.HTTPHeader == nil {
+		o.HTTPHeader = http.Header{}
+	}
+	if c.compression != nil {
+		o.CompressionMode = c.compression.mode
+		o.CompressionThreshold = c.compression.threshold
+	}
+	if c.transport != nil {
+		if o.HTTPClient, err = c.transport.client(o.HTTPClient); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.subprotocols) != 0 {
+		o.Subprotocols = append(append([]string{}, o.Subprotocols...), c.subprotocols...)
+	}
+	return &o, nil
+}
+
+// reader is a helper func to pump messages from a connection,
+// applying the inbound backpressure policy when data is full. It
+// gives up once done closes, as the run it feeds returns.
+func (c *WSClient) reader(conn *websocket.Conn, data chan wsMessage, errs chan error, done <-chan struct{}) {
 	defer close(data)
 	defer close(errs)
 	for {
-		_, bts, err := conn.Read(context.Background())
+		typ, bts, err := conn.Read(context.Background())
 		if err != nil {
 			errs <- err
 			return
 		}
-		data <- bts
+		if !c.pushInbound(data, wsMessage{typ: typ, bts: bts}, done) {
+			return
+		}
+		if c.readThrottle != nil {
+			c.readThrottle.wait(c.clock, len(bts))
+		}
 	}
 }
 
-// reconnectPolicy configures reconnect behavior.
-// if the function returns true, the client will
-// attempt a reconnect.
-type reconnectPolicy func(error) bool
+// ReconnectPolicy configures reconnect behavior. After each disconnect, Reconnect
+// is called with the error that caused it, the number of the reconnect attempt about
+// to be made (starting at 1 after each successful connection), and how long the
+// client has been disconnected. If retry is true, the client waits delay and then
+// reconnects.
+type ReconnectPolicy interface {
+	Reconnect(err error, attempt int, elapsed time.Duration) (retry bool, delay time.Duration)
+}
+
+// ReconnectFunc adapts a function to a ReconnectPolicy.
+type ReconnectFunc func(err error, attempt int, elapsed time.Duration) (bool, time.Duration)
+
+func (fn ReconnectFunc) Reconnect(err error, attempt int, elapsed time.Duration) (bool, time.Duration) {
+	return fn(err, attempt, elapsed)
+}
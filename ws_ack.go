@@ -0,0 +1,79 @@
+package apic
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrNack is returned by a handler, or wrapped by the error it returns, to
+	// reject a message. It is redelivered to the handler up to the configured
+	// limit, after which a nack frame is sent.
+	ErrNack = errors.New("message nacked")
+
+	// ErrAckLater is returned by a handler that will acknowledge the message
+	// itself, with WSClient.Ack or WSClient.Nack.
+	ErrAckLater = errors.New("message ack deferred")
+
+	errNoAckEncoder = errors.New("websocket client has no ack encoder")
+)
+
+// AckEncoder builds the protocol specific frames acknowledging, or rejecting, an
+// inbound message. A nil frame means nothing is sent.
+type AckEncoder interface {
+	Ack(msg []byte) ([]byte, error)
+	Nack(msg []byte) ([]byte, error)
+}
+
+type acks struct {
+	encoder         AckEncoder
+	maxRedeliveries int
+}
+
+// deliver calls the handler with msg, acknowledging it once handled, and
+// redelivering it when nacked.
+func (c *WSClient) deliver(msg wsMessage) error {
+	if c.acks == nil {
+		return c.callHandler(msg)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := c.callHandler(msg)
+		switch {
+		case err == nil:
+			return c.Ack(context.Background(), msg.bts)
+		case errors.Is(err, ErrAckLater):
+			return nil
+		case !errors.Is(err, ErrNack):
+			return err
+		case attempt >= c.acks.maxRedeliveries:
+			c.logger.Info("message nacked, redeliveries exhausted", "attempts", attempt+1)
+			return c.Nack(context.Background(), msg.bts)
+		}
+		c.logger.Debug("redelivering nacked message", "attempt", attempt+1)
+	}
+}
+
+// Ack sends the ack frame for msg. See WithAcks.
+func (c *WSClient) Ack(ctx context.Context, msg []byte) error {
+	if c.acks == nil {
+		return errNoAckEncoder
+	}
+	frame, err := c.acks.encoder.Ack(msg)
+	if err != nil || frame == nil {
+		return err
+	}
+	return c.send(ctx, c.messageType, frame)
+}
+
+// Nack sends the nack frame for msg. See WithAcks.
+func (c *WSClient) Nack(ctx context.Context, msg []byte) error {
+	if c.acks == nil {
+		return errNoAckEncoder
+	}
+	frame, err := c.acks.encoder.Nack(msg)
+	if err != nil || frame == nil {
+		return err
+	}
+	return c.send(ctx, c.messageType, frame)
+}
@@ -0,0 +1,31 @@
+package apic
+
+// The helpers here let protocol adapters layered on WSClient hook in to the
+// connection lifecycle without clobbering the caller's own options.
+
+// chainOnOpen runs fn after the currently configured onOpen callback.
+func (c *WSClient) chainOnOpen(fn func(*WSClient) error) {
+	prev := c.onOpen
+	c.onOpen = func(ws *WSClient) error {
+		if err := prev(ws); err != nil {
+			return err
+		}
+		return fn(ws)
+	}
+}
+
+// chainOnClose runs fn before the currently configured onClose callback.
+func (c *WSClient) chainOnClose(fn func(*WSClient) error) {
+	prev := c.onClose
+	c.onClose = func(ws *WSClient) error {
+		if err := fn(ws); err != nil {
+			return err
+		}
+		return prev(ws)
+	}
+}
+
+// addSubprotocols requests protos, in addition to any the caller has requested.
+func (c *WSClient) addSubprotocols(protos ...string) {
+	c.subprotocols = append(c.subprotocols, protos...)
+}
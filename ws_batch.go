@@ -0,0 +1,116 @@
+package apic
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+// BatchFormat is how batched messages are joined in to a single frame.
+type BatchFormat int
+
+const (
+	// BatchArray joins messages as elements of a json array.
+	BatchArray BatchFormat = iota
+
+	// BatchNewline joins messages with newlines.
+	BatchNewline
+)
+
+// defaultBatchFlushTimeout bounds a batch's write when none of its callers' contexts
+// has a deadline.
+const defaultBatchFlushTimeout = time.Second * 10
+
+// writeBatcher coalesces messages passed to Write and Send in to single frames.
+type writeBatcher struct {
+	max      int
+	interval time.Duration
+
+	mu      sync.Mutex
+	current *pendingBatch
+}
+
+type pendingBatch struct {
+	msgs  [][]byte
+	timer Timer
+	done  chan struct{}
+	err   error
+
+	// deadline is the earliest of the callers' deadlines, if any has one
+	deadline time.Time
+}
+
+// batchWrite adds bts to the current batch, and waits for the batch to be written.
+func (c *WSClient) batchWrite(ctx context.Context, bts []byte) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	b := c.batcher
+
+	b.mu.Lock()
+	if b.current == nil {
+		pb := &pendingBatch{done: make(chan struct{}), timer: c.clock.NewTimer(b.interval)}
+		go func() {
+			select {
+			case <-pb.timer.C():
+				c.flushBatch(pb)
+			case <-pb.done:
+			}
+		}()
+		b.current = pb
+	}
+	pb := b.current
+	pb.msgs = append(pb.msgs, bts)
+	if d, ok := ctx.Deadline(); ok && (pb.deadline.IsZero() || d.Before(pb.deadline)) {
+		pb.deadline = d
+	}
+	full := len(pb.msgs) >= b.max
+	b.mu.Unlock()
+
+	if full {
+		c.flushBatch(pb)
+	}
+
+	select {
+	case <-pb.done:
+		return pb.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flushBatch writes pb as a single frame, unless it has already been written.
+func (c *WSClient) flushBatch(pb *pendingBatch) {
+	b := c.batcher
+
+	b.mu.Lock()
+	if b.current != pb {
+		b.mu.Unlock()
+		return
+	}
+	b.current = nil
+	deadline := pb.deadline
+	b.mu.Unlock()
+
+	pb.timer.Stop()
+	if deadline.IsZero() {
+		deadline = time.Now().Add(defaultBatchFlushTimeout)
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	pb.err = c.send(ctx, c.messageType, c.joinBatch(pb.msgs))
+	close(pb.done)
+}
+
+func (c *WSClient) joinBatch(msgs [][]byte) []byte {
+	if c.batchFormat == BatchNewline {
+		return bytes.Join(msgs, []byte("\n"))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	buf.Write(bytes.Join(msgs, []byte(",")))
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
@@ -0,0 +1,154 @@
+package apic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+var ErrCallTimeout = errors.New("call timed out waiting for response")
+
+// CallIDAttacher attaches a correlation id to an outgoing call request,
+// returning the object that should be written to the connection.
+type CallIDAttacher func(id string, req any) (any, error)
+
+// CallIDExtractor pulls the correlation id out of an inbound message.
+// ok should be false for messages that aren't call responses.
+type CallIDExtractor func(msg []byte) (id string, ok bool)
+
+const defaultCallTimeout = time.Second * 10
+
+// Call attaches a correlation id to req, writes it to the current connection,
+// and waits for the response carrying the same id. Responses matched to a call
+// are not passed to the global handler.
+//
+// The default ids are sequential, "1", "2", and so on, so while a call is waiting,
+// any message with a matching "id", ie, a feed message with "id":1, is taken as
+// its response. Use WithCallIDs to correlate by a field, or id scheme, that feed
+// messages don't share.
+func (c *WSClient) Call(ctx context.Context, req any) (json.RawMessage, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	id := strconv.FormatUint(c.callSeq.Add(1), 10)
+	msg, err := c.callAttach(id, req)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, done := c.registerCall(id)
+	defer done()
+
+	if err := c.Write(ctx, msg); err != nil {
+		return nil, err
+	}
+
+	return c.awaitCall(ctx, rsp)
+}
+
+// registerCall registers a pending call under id. The returned func
+// must be called once the caller is no longer waiting on the response.
+func (c *WSClient) registerCall(id string) (chan []byte, func()) {
+	rsp := make(chan []byte, 1)
+	c.callsMu.Lock()
+	if c.calls == nil {
+		c.calls = map[string]chan []byte{}
+	}
+	c.calls[id] = rsp
+	c.callsMu.Unlock()
+	return rsp, func() {
+		c.callsMu.Lock()
+		delete(c.calls, id)
+		c.callsMu.Unlock()
+	}
+}
+
+// awaitCall waits for a registered call's response, honoring the call timeout.
+func (c *WSClient) awaitCall(ctx context.Context, rsp chan []byte) ([]byte, error) {
+	timeout := c.callTimeout
+	if timeout == 0 {
+		timeout = defaultCallTimeout
+	}
+	t := c.clock.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case bts := <-rsp:
+		return bts, nil
+	case <-t.C():
+		return nil, ErrCallTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// resolveCall hands msg to a waiting Call, if it is a response to one. Messages
+// aren't inspected at all while no call is waiting.
+func (c *WSClient) resolveCall(msg []byte) bool {
+	c.callsMu.Lock()
+	waiting := len(c.calls) != 0
+	c.callsMu.Unlock()
+	if !waiting {
+		return false
+	}
+
+	id, ok := c.callExtract(msg)
+	if !ok {
+		return false
+	}
+	return c.deliverCall(id, msg)
+}
+
+// deliverCall hands msg to the call registered under id, if there is one.
+func (c *WSClient) deliverCall(id string, msg []byte) bool {
+	c.callsMu.Lock()
+	rsp, ok := c.calls[id]
+	c.callsMu.Unlock()
+	if !ok {
+		return false
+	}
+
+	select {
+	case rsp <- msg:
+	default:
+	}
+	return true
+}
+
+// attachJSONID is the default CallIDAttacher: it sets an "id" field on the
+// request's json object representation.
+func attachJSONID(id string, req any) (any, error) {
+	bts, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	obj := map[string]json.RawMessage{}
+	if err := json.Unmarshal(bts, &obj); err != nil {
+		return nil, err
+	}
+	obj["id"], _ = json.Marshal(id)
+	return obj, nil
+}
+
+// extractJSONID is the default CallIDExtractor: it reads a string or numeric
+// "id" field from a json object message.
+func extractJSONID(msg []byte) (string, bool) {
+	var obj struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(msg, &obj); err != nil || len(obj.ID) == 0 {
+		return "", false
+	}
+	var s string
+	if err := json.Unmarshal(obj.ID, &s); err == nil {
+		return s, true
+	}
+	var n json.Number
+	if err := json.Unmarshal(obj.ID, &n); err == nil {
+		return n.String(), true
+	}
+	return "", false
+}
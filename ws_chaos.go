@@ -0,0 +1,63 @@
+package apic
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+var (
+	ErrChaosDisconnect = errors.New("chaos: injected disconnect")
+	ErrChaosHandshake  = errors.New("chaos: injected handshake failure")
+)
+
+// ChaosConfig configures fault injection. Rates are probabilities, from 0 to 1.
+type ChaosConfig struct {
+	// HandshakeFailureRate is the chance each dial fails with ErrChaosHandshake.
+	HandshakeFailureRate float64
+
+	// DisconnectRate is the chance the connection is dropped, with
+	// ErrChaosDisconnect, on receiving each message.
+	DisconnectRate float64
+
+	// DelayRate is the chance each message is delayed by up to MaxDelay before
+	// being handled, holding up the messages behind it.
+	DelayRate float64
+	MaxDelay  time.Duration
+
+	// DuplicateRate is the chance each message is handled twice.
+	DuplicateRate float64
+}
+
+func (cfg *ChaosConfig) roll(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// handshake fails a dial, by chance.
+func (cfg *ChaosConfig) handshake() error {
+	if cfg != nil && cfg.roll(cfg.HandshakeFailureRate) {
+		return ErrChaosHandshake
+	}
+	return nil
+}
+
+// inbound applies chaos to a received message, returning how many times it
+// should be handled, or an error to disconnect.
+func (cfg *ChaosConfig) inbound(c *WSClient) (int, error) {
+	if cfg == nil {
+		return 1, nil
+	}
+	if cfg.roll(cfg.DisconnectRate) {
+		return 0, ErrChaosDisconnect
+	}
+	if cfg.MaxDelay > 0 && cfg.roll(cfg.DelayRate) {
+		d := time.Duration(rand.Int63n(int64(cfg.MaxDelay)))
+		c.logger.Debug("chaos: delaying message", "delay", d.String())
+		sleep(c.clock, d)
+	}
+	if cfg.roll(cfg.DuplicateRate) {
+		c.logger.Debug("chaos: duplicating message")
+		return 2, nil
+	}
+	return 1, nil
+}
@@ -0,0 +1,142 @@
+package apic
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrPoolFull = errors.New("websocket pool has no connection with room for another key")
+
+// WSPoolConfig configures a WSPool.
+type WSPoolConfig struct {
+	// Size is the number of connections. Defaults to 1.
+	Size int
+
+	// MaxKeysPerConn caps the keys assigned to each connection, ie, an exchange's
+	// subscription limit. Zero means no cap.
+	MaxKeysPerConn int
+}
+
+// WSPool runs several WSClients to the same endpoint, sharding keys (typically
+// subscriptions) across them. Each key is assigned to the connection with the
+// fewest keys the first time it is seen, and sticks to it until released.
+type WSPool struct {
+	cfg     WSPoolConfig
+	clients []*WSClient
+
+	mu   sync.Mutex
+	keys map[string]int
+	load []int
+}
+
+// NewWSPool creates a pool of clients for the endpoint, each created with opts.
+// Callbacks such as WithWSOnOpen are shared by every client, and can use Keys to
+// find the keys assigned to the client they're called for.
+func NewWSPool(endpoint string, cfg WSPoolConfig, opts ...WSOption) *WSPool {
+	if cfg.Size < 1 {
+		cfg.Size = 1
+	}
+	p := &WSPool{
+		cfg:  cfg,
+		keys: map[string]int{},
+		load: make([]int, cfg.Size),
+	}
+	for i := 0; i < cfg.Size; i++ {
+		p.clients = append(p.clients, NewWSClient(endpoint, opts...))
+	}
+	return p
+}
+
+// Start runs every client until ctx is canceled, or one of them returns, at which
+// point the rest are stopped. It returns the first error a client returned.
+func (p *WSPool) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(p.clients))
+	for _, c := range p.clients {
+		go func(c *WSClient) {
+			errs <- c.Start(ctx)
+		}(c)
+	}
+
+	var first error
+	for range p.clients {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+		cancel()
+	}
+	return first
+}
+
+// Clients returns the pool's clients.
+func (p *WSPool) Clients() []*WSClient {
+	return p.clients
+}
+
+// Client returns the client key is assigned to, assigning it if need be.
+func (p *WSPool) Client(key string) (*WSClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if i, ok := p.keys[key]; ok {
+		return p.clients[i], nil
+	}
+
+	best := 0
+	for i, n := range p.load {
+		if n < p.load[best] {
+			best = i
+		}
+	}
+	if p.cfg.MaxKeysPerConn != 0 && p.load[best] >= p.cfg.MaxKeysPerConn {
+		return nil, ErrPoolFull
+	}
+	p.keys[key] = best
+	p.load[best]++
+	return p.clients[best], nil
+}
+
+// Release unassigns key, freeing room on its client.
+func (p *WSPool) Release(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if i, ok := p.keys[key]; ok {
+		delete(p.keys, key)
+		p.load[i]--
+	}
+}
+
+// Keys returns the keys assigned to c.
+func (p *WSPool) Keys(c *WSClient) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var keys []string
+	for k, i := range p.keys {
+		if p.clients[i] == c {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Write encodes and writes obj on the client key is assigned to.
+func (p *WSPool) Write(ctx context.Context, key string, obj any) error {
+	c, err := p.Client(key)
+	if err != nil {
+		return err
+	}
+	return c.Write(ctx, obj)
+}
+
+// Send writes already encoded bytes on the client key is assigned to.
+func (p *WSPool) Send(ctx context.Context, key string, bts []byte) error {
+	c, err := p.Client(key)
+	if err != nil {
+		return err
+	}
+	return c.Send(ctx, bts)
+}
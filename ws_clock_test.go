@@ -0,0 +1,173 @@
+package apic_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rileyr/apic"
+	"github.com/rileyr/apic/apictest"
+)
+
+// eventually waits for cond, which the client reaches on its own goroutines.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// start runs c until the test ends, returning what Start returns.
+func start(t *testing.T, c *apic.WSClient) <-chan error {
+	ctx, cancel := context.WithCancel(context.Background())
+	errc, done := make(chan error, 1), make(chan struct{})
+	go func() {
+		defer close(done)
+		errc <- c.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return errc
+}
+
+func stopped(t *testing.T, errc <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("client didn't stop")
+		return nil
+	}
+}
+
+func running(t *testing.T, errc <-chan error) {
+	t.Helper()
+	select {
+	case err := <-errc:
+		t.Fatalf("client stopped early: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestStaleDetection(t *testing.T) {
+	srv := apictest.NewWSServer(apictest.Script{apictest.Send("hi")})
+	defer srv.Close()
+
+	clk := apictest.NewClock(time.Unix(0, 0))
+	received := make(chan struct{}, 1)
+	c := apic.NewWSClient(srv.URL,
+		apic.WithClock(clk),
+		apic.WithStaleDetection(10*time.Second),
+		apic.WithWSHandler(func([]byte) error {
+			received <- struct{}{}
+			return nil
+		}),
+	)
+	errc := start(t, c)
+	<-received
+	eventually(t, "the stale check", func() bool { return clk.Waiters() == 1 })
+
+	clk.Advance(9 * time.Second)
+	running(t, errc)
+
+	clk.Advance(2 * time.Second)
+	if err := stopped(t, errc); !errors.Is(err, apic.ErrStaleConnection) {
+		t.Fatalf("got %v, want %v", err, apic.ErrStaleConnection)
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	srv := apictest.NewWSServer(apictest.Script{apictest.Reject(http.StatusServiceUnavailable)})
+	defer srv.Close()
+
+	var (
+		mu      sync.Mutex
+		elapsed []time.Duration
+	)
+	clk := apictest.NewClock(time.Unix(0, 0))
+	c := apic.NewWSClient(srv.URL,
+		apic.WithClock(clk),
+		apic.WithReconnectPolicy(apic.ReconnectFunc(func(_ error, attempt int, e time.Duration) (bool, time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			elapsed = append(elapsed, e)
+			return true, time.Duration(attempt) * 5 * time.Second
+		})),
+	)
+	start(t, c)
+
+	// attempt n waits n*5s, on the fake clock alone
+	for attempt, wait := range []time.Duration{5 * time.Second, 10 * time.Second} {
+		eventually(t, "the backoff", func() bool { return clk.Waiters() == 1 })
+		clk.Advance(wait - time.Second)
+		if n := srv.Connections(); n != attempt+1 {
+			t.Fatalf("attempt %d: got %d connections before the backoff, want %d", attempt+1, n, attempt+1)
+		}
+		clk.Advance(time.Second)
+		eventually(t, "the reconnect", func() bool { return srv.Connections() == attempt+2 })
+	}
+
+	eventually(t, "the third failure", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(elapsed) == 3
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	want := []time.Duration{0, 5 * time.Second, 15 * time.Second}
+	for i := range want {
+		if elapsed[i] != want[i] {
+			t.Errorf("attempt %d: got elapsed %v, want %v", i+1, elapsed[i], want[i])
+		}
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	srv := apictest.NewWSServer(apictest.Script{
+		apictest.Expect("hb"),
+		apictest.Send("ack"),
+		apictest.Expect("hb"),
+	})
+	defer srv.Close()
+
+	clk := apictest.NewClock(time.Unix(0, 0))
+	replied := make(chan struct{}, 1)
+	c := apic.NewWSClient(srv.URL,
+		apic.WithClock(clk),
+		apic.WithHeartbeat([]byte("hb"), func(msg []byte) bool {
+			if string(msg) != "ack" {
+				return false
+			}
+			replied <- struct{}{}
+			return true
+		}, 10*time.Second, 5*time.Second),
+	)
+	errc := start(t, c)
+
+	// the stale check and heartbeat tickers, plus the reply timeout while one
+	// is pending
+	eventually(t, "the heartbeat ticker", func() bool { return clk.Waiters() == 2 })
+	clk.Advance(10 * time.Second)
+	<-replied
+	eventually(t, "the reply", func() bool { return clk.Waiters() == 2 })
+
+	clk.Advance(10 * time.Second)
+	eventually(t, "the second heartbeat", func() bool { return clk.Waiters() == 3 })
+	clk.Advance(4 * time.Second)
+	running(t, errc)
+
+	clk.Advance(time.Second)
+	if err := stopped(t, errc); !errors.Is(err, apic.ErrHeartbeatTimeout) {
+		t.Fatalf("got %v, want %v", err, apic.ErrHeartbeatTimeout)
+	}
+}
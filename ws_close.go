@@ -0,0 +1,96 @@
+package apic
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// StatusCode is a websocket close status code.
+type StatusCode = websocket.StatusCode
+
+// closeStatus is the close frame a client sends when it is stopped, and how
+// long it waits for the peer to acknowledge it.
+type closeStatus struct {
+	code   StatusCode
+	reason string
+	wait   time.Duration
+}
+
+// CloseWithStatus stops the client, closing the connection with the given status
+// code and reason, and waiting up to wait for the peer's close frame in reply
+// before dropping the connection. Zero wait uses the websocket library's default.
+// Start then returns nil. CloseWithStatus waits for it to, up to ctx; Start only
+// returns once in flight handlers and callbacks do, so from within one, ctx must
+// end for CloseWithStatus to return.
+func (c *WSClient) CloseWithStatus(ctx context.Context, code StatusCode, reason string, wait time.Duration) error {
+	c.mu.Lock()
+	c.draining = true
+	c.closeStatus = &closeStatus{code: code, reason: reason, wait: wait}
+	c.mu.Unlock()
+
+	d := c.currentDrainer()
+	d.stop()
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LastClose returns the close frame the server sent to end the most recent
+// connection, or nil if it ended some other way, or hasn't ended.
+func (c *WSClient) LastClose() *CloseError {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastClose
+}
+
+// readError records the server's close frame if err is the result of one,
+// converting it to a *CloseError.
+func (c *WSClient) readError(err error) error {
+	var ce websocket.CloseError
+	if !errors.As(err, &ce) {
+		return err
+	}
+	cerr := &CloseError{Code: ce.Code, Reason: ce.Reason, Err: err}
+	c.mu.Lock()
+	c.lastClose = cerr
+	c.mu.Unlock()
+	return cerr
+}
+
+// closeConn closes conn once the client is done with it.
+func (c *WSClient) closeConn(conn *websocket.Conn) {
+	cs := &closeStatus{code: websocket.StatusInternalError, reason: "app closing"}
+	c.mu.Lock()
+	if c.drainer.stopped() {
+		if c.closeStatus != nil {
+			cs = c.closeStatus
+		} else {
+			cs = &closeStatus{code: websocket.StatusNormalClosure}
+		}
+	}
+	c.mu.Unlock()
+
+	if cs.wait == 0 {
+		conn.Close(cs.code, cs.reason)
+		return
+	}
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.Close(cs.code, cs.reason)
+	}()
+	t := c.clock.NewTimer(cs.wait)
+	defer t.Stop()
+	select {
+	case <-closed:
+	case <-t.C():
+		c.logger.Info("close not acknowledged, dropping connection")
+		conn.CloseNow()
+	}
+}
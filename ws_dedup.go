@@ -0,0 +1,45 @@
+package apic
+
+import "time"
+
+// dedupWindow remembers message ids seen within a sliding window. It is only
+// used from the read loop.
+type dedupWindow struct {
+	extract func([]byte) string
+	window  time.Duration
+
+	seen  map[string]time.Time
+	order []dedupEntry
+}
+
+type dedupEntry struct {
+	id string
+	at time.Time
+}
+
+// duplicate reports whether msg's id has been seen within the window, recording
+// it if not. Messages without an id are never duplicates.
+func (d *dedupWindow) duplicate(msg []byte, now time.Time) bool {
+	id := d.extract(msg)
+	if id == "" {
+		return false
+	}
+
+	d.expire(now)
+	if _, ok := d.seen[id]; ok {
+		return true
+	}
+	d.seen[id] = now
+	d.order = append(d.order, dedupEntry{id: id, at: now})
+	return false
+}
+
+// expire forgets ids seen before the window.
+func (d *dedupWindow) expire(now time.Time) {
+	cutoff := now.Add(-d.window)
+	i := 0
+	for ; i < len(d.order) && d.order[i].at.Before(cutoff); i++ {
+		delete(d.seen, d.order[i].id)
+	}
+	d.order = d.order[i:]
+}
@@ -0,0 +1,117 @@
+package apic
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrDraining = errors.New("websocket client draining")
+
+// errDrained ends the connection once a drain has flushed it.
+var errDrained = errors.New("websocket client drained")
+
+// drainer tracks a client being drained. drain is closed to stop the client
+// once outbound messages are flushed, and done once Start has returned.
+type drainer struct {
+	once  sync.Once
+	drain chan struct{}
+
+	doneOnce sync.Once
+	done     chan struct{}
+}
+
+func newDrainer() *drainer {
+	return &drainer{drain: make(chan struct{}), done: make(chan struct{})}
+}
+
+func (d *drainer) stop() {
+	d.once.Do(func() { close(d.drain) })
+}
+
+func (d *drainer) stopped() bool {
+	select {
+	case <-d.drain:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *drainer) finished() {
+	d.doneOnce.Do(func() { close(d.done) })
+}
+
+func (d *drainer) isFinished() bool {
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// currentDrainer returns the drainer of the client's current, or next, run.
+func (c *WSClient) currentDrainer() *drainer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.drainer
+}
+
+// resetDrainer readies a client that was drained, or closed with a status, to be
+// started again, returning the drainer for the run about to start. A drain asked
+// for before the run starts still applies to it.
+func (c *WSClient) resetDrainer() *drainer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.drainer.isFinished() {
+		c.drainer = newDrainer()
+		c.draining = false
+		c.closeStatus = nil
+	}
+	return c.drainer
+}
+
+func (c *WSClient) isDraining() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.draining
+}
+
+// Drain gracefully stops the client: new writes are rejected with ErrDraining,
+// batched and queued messages are flushed (waiting for a connection if need be),
+// in flight handlers are left to finish, and the connection is closed with a normal
+// closure. Start then returns nil. Drain waits for all of this, up to ctx.
+func (c *WSClient) Drain(ctx context.Context) error {
+	c.mu.Lock()
+	c.draining = true
+	queued := c.queue != nil && len(c.queue.messages) != 0
+	c.mu.Unlock()
+
+	if c.batcher != nil {
+		c.batcher.mu.Lock()
+		pb := c.batcher.current
+		c.batcher.mu.Unlock()
+		if pb != nil {
+			c.flushBatch(pb)
+		}
+	}
+
+	if queued {
+		if err := c.WaitForConnection(ctx); err != nil {
+			return err
+		}
+		if err := c.flushQueue(ctx); err != nil {
+			return err
+		}
+	}
+
+	d := c.currentDrainer()
+	d.stop()
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,216 @@
+package apic
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// errFailback ends a connection to a backup endpoint once the primary is healthy.
+var errFailback = errors.New("websocket primary endpoint healthy, failing back")
+
+// errMigrate ends a connection to move it to the endpoint set with SetEndpoint.
+var errMigrate = errors.New("websocket endpoint changed")
+
+// endpointStrategy picks the endpoint for each dial, and is told how each went.
+type endpointStrategy interface {
+	next(now time.Time) string
+	report(endpoint string, err error, now time.Time)
+}
+
+// failoverEndpoints dials the current endpoint until it fails, then moves on to
+// the next, in priority order.
+type failoverEndpoints struct {
+	endpoints []string
+
+	mu  sync.Mutex
+	idx int
+}
+
+func (f *failoverEndpoints) next(_ time.Time) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.endpoints[f.idx]
+}
+
+// report moves on from endpoint if it failed. Reports for any other endpoint,
+// ie, one dialed before a failback, are stale and ignored.
+func (f *failoverEndpoints) report(endpoint string, err error, _ time.Time) {
+	if err == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if endpoint != f.endpoints[f.idx] {
+		return
+	}
+	f.idx = (f.idx + 1) % len(f.endpoints)
+}
+
+func (f *failoverEndpoints) primary() string {
+	return f.endpoints[0]
+}
+
+// failback switches back to the primary endpoint.
+func (f *failoverEndpoints) failback() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.idx = 0
+}
+
+// endpointCooldown is how long an endpoint is skipped after a failed dial, doubling
+// with each consecutive failure up to maxEndpointCooldown.
+const (
+	endpointCooldown    = time.Second
+	maxEndpointCooldown = time.Minute
+)
+
+// roundRobinEndpoints rotates through equivalent endpoints on each dial, skipping
+// those that recently failed.
+type roundRobinEndpoints struct {
+	endpoints []string
+
+	mu       sync.Mutex
+	idx      int
+	failures map[string]int
+	until    map[string]time.Time
+}
+
+func newRoundRobinEndpoints(endpoints []string) *roundRobinEndpoints {
+	return &roundRobinEndpoints{
+		endpoints: endpoints,
+		failures:  map[string]int{},
+		until:     map[string]time.Time{},
+	}
+}
+
+// next returns the next endpoint not cooling down, or if they all are, the one
+// that will recover soonest.
+func (r *roundRobinEndpoints) next(now time.Time) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	best := -1
+	for i := range r.endpoints {
+		j := (r.idx + i) % len(r.endpoints)
+		until := r.until[r.endpoints[j]]
+		if !until.After(now) {
+			best = j
+			break
+		}
+		if best < 0 || until.Before(r.until[r.endpoints[best]]) {
+			best = j
+		}
+	}
+	r.idx = (best + 1) % len(r.endpoints)
+	return r.endpoints[best]
+}
+
+func (r *roundRobinEndpoints) report(endpoint string, err error, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err == nil {
+		delete(r.failures, endpoint)
+		delete(r.until, endpoint)
+		return
+	}
+	r.failures[endpoint]++
+	cooldown := endpointCooldown << (r.failures[endpoint] - 1)
+	if cooldown > maxEndpointCooldown || cooldown <= 0 {
+		cooldown = maxEndpointCooldown
+	}
+	r.until[endpoint] = now.Add(cooldown)
+}
+
+// nextEndpoint is the endpoint to dial next.
+func (c *WSClient) nextEndpoint() string {
+	c.mu.Lock()
+	override := c.override
+	c.mu.Unlock()
+	if override != "" {
+		return override
+	}
+	if c.endpoints == nil {
+		return c.endpoint
+	}
+	return c.endpoints.next(c.clock.Now())
+}
+
+// Endpoint returns the endpoint of the current, or most recent, connection.
+func (c *WSClient) Endpoint() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.endpointLocked()
+}
+
+// endpointLocked must be called with mu held.
+func (c *WSClient) endpointLocked() string {
+	if c.current == "" {
+		return c.endpoint
+	}
+	return c.current
+}
+
+// SetEndpoint moves the client to endpoint: the current connection, if any, is
+// closed, and the client reconnects to endpoint straight away, without backoff.
+// Every later dial uses endpoint too, in place of those the client was created
+// with. The client's handlers, subscriptions and options carry over, as for any
+// other reconnect.
+func (c *WSClient) SetEndpoint(endpoint string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.override = endpoint
+	if c.migrate == nil {
+		return
+	}
+	select {
+	case c.migrate <- struct{}{}:
+	default:
+	}
+}
+
+// failbackProbe returns a channel that fires when the connection should be checked
+// for failing back to the primary endpoint, or nil if it's on the primary already.
+func (c *WSClient) failbackProbe(connected string) (<-chan struct{}, func()) {
+	c.mu.Lock()
+	override := c.override
+	c.mu.Unlock()
+	f, ok := c.endpoints.(*failoverEndpoints)
+	if !ok || override != "" || connected == f.primary() || c.failbackInterval == 0 {
+		return nil, func() {}
+	}
+	healthy := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for c.wait(ctx, c.failbackInterval) {
+			if c.probe(ctx, f.primary()) {
+				f.failback()
+				close(healthy)
+				return
+			}
+		}
+	}()
+	return healthy, cancel
+}
+
+// probe reports whether endpoint accepts a connection.
+func (c *WSClient) probe(ctx context.Context, endpoint string) bool {
+	opts, err := c.dialOptions()
+	if err != nil {
+		return false
+	}
+	if endpoint, err = c.authorize(ctx, endpoint, opts); err != nil {
+		return false
+	}
+	conn, _, err := websocket.Dial(ctx, endpoint, opts)
+	if err != nil {
+		c.logger.Debug("primary endpoint probe failed", "error", err.Error())
+		return false
+	}
+	conn.Close(websocket.StatusNormalClosure, "probe")
+	return true
+}
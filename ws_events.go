@@ -0,0 +1,68 @@
+package apic
+
+import "time"
+
+// WSEvent is a connection lifecycle event, one of ConnectedEvent, DisconnectedEvent,
+// StaleDetectedEvent, or ReconnectScheduledEvent.
+type WSEvent interface {
+	wsEvent()
+}
+
+// ConnectedEvent is emitted once a connection is open.
+type ConnectedEvent struct {
+	Endpoint string
+}
+
+// DisconnectedEvent is emitted each time a connection ends. Err is nil if the
+// connection ended because the client's context was canceled.
+type DisconnectedEvent struct {
+	Err error
+}
+
+// StaleDetectedEvent is emitted when stale detection closes a connection.
+// Class is the message class that went stale, with WithStaleClassTimeouts, or ""
+// for the connection as a whole.
+type StaleDetectedEvent struct {
+	LastMessage time.Time
+	Class       string
+}
+
+// ReconnectScheduledEvent is emitted when the reconnect policy schedules a reconnect.
+type ReconnectScheduledEvent struct {
+	Attempt int
+	Delay   time.Duration
+}
+
+func (ConnectedEvent) wsEvent()          {}
+func (DisconnectedEvent) wsEvent()       {}
+func (StaleDetectedEvent) wsEvent()      {}
+func (ReconnectScheduledEvent) wsEvent() {}
+
+// eventBuffer is how many events may go unread before further events are dropped.
+const eventBuffer = 64
+
+// Events returns the channel lifecycle events are emitted on. Events are only
+// emitted once Events has been called, and are dropped if the channel is full.
+func (c *WSClient) Events() <-chan WSEvent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.events == nil {
+		c.events = make(chan WSEvent, eventBuffer)
+	}
+	return c.events
+}
+
+func (c *WSClient) emit(ev WSEvent) {
+	c.mu.Lock()
+	events := c.events
+	c.mu.Unlock()
+	if events == nil {
+		return
+	}
+
+	select {
+	case events <- ev:
+	default:
+		c.logger.Debug("dropping event, channel full")
+	}
+}
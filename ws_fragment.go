@@ -0,0 +1,207 @@
+package apic
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// fragmentTTL is how long a partially received message is held for its
+// remaining chunks.
+const fragmentTTL = time.Minute
+
+// Limits on what a peer can make the reassembly hold: the chunks a message may be
+// split in to, the partial messages held at once, and the bytes held across them.
+const (
+	maxFragmentChunks   = 1 << 16
+	maxFragmentPartials = 1024
+	maxFragmentBytes    = 64 << 20
+)
+
+// Chunk is a piece of an outbound message fragmented by WithFragmentation.
+type Chunk struct {
+	ID    string `json:"chunk_id"`
+	Index int    `json:"chunk_index"`
+	Total int    `json:"chunk_total"`
+	Data  []byte `json:"chunk_data"`
+}
+
+// ChunkCodec is the envelope chunks are sent in. Decode reports whether a message
+// is a chunk at all; messages that aren't are passed through untouched.
+type ChunkCodec struct {
+	Encode func(Chunk) ([]byte, error)
+	Decode func([]byte) (Chunk, bool)
+}
+
+// JSONChunks is the default chunk envelope: a json object with the chunk's fields,
+// and its data base64 encoded.
+var JSONChunks = ChunkCodec{
+	Encode: func(ch Chunk) ([]byte, error) {
+		return json.Marshal(ch)
+	},
+	Decode: func(bts []byte) (Chunk, bool) {
+		var ch Chunk
+		if err := json.Unmarshal(bts, &ch); err != nil || ch.ID == "" || ch.Total == 0 {
+			return Chunk{}, false
+		}
+		return ch, true
+	},
+}
+
+var (
+	errBadChunk      = errors.New("chunk out of range")
+	errFragmentLimit = errors.New("too many fragmented messages held")
+)
+
+// partialMessage is a fragmented message being reassembled.
+type partialMessage struct {
+	chunks   [][]byte
+	received int
+	size     int
+	started  time.Time
+}
+
+// fragmentMiddleware splits outbound messages over maxSize bytes in to chunks, and
+// reassembles inbound chunks.
+func fragmentMiddleware(maxSize int, codec ChunkCodec, clock func() time.Time) (InboundMiddleware, OutboundMiddleware) {
+	var mu sync.Mutex
+	partials := map[string]*partialMessage{}
+	held := 0
+
+	in := func(next InboundFunc) InboundFunc {
+		return func(typ MessageType, bts []byte) error {
+			ch, ok := codec.Decode(bts)
+			if !ok {
+				return next(typ, bts)
+			}
+			if ch.Total > maxFragmentChunks || ch.Index < 0 || ch.Index >= ch.Total || len(ch.Data) > maxSize {
+				return errBadChunk
+			}
+
+			mu.Lock()
+			now := clock()
+			for id, p := range partials {
+				if now.Sub(p.started) > fragmentTTL {
+					held -= p.size
+					delete(partials, id)
+				}
+			}
+			p, ok := partials[ch.ID]
+			if !ok {
+				if len(partials) >= maxFragmentPartials {
+					mu.Unlock()
+					return errFragmentLimit
+				}
+				p = &partialMessage{chunks: make([][]byte, ch.Total), started: now}
+				partials[ch.ID] = p
+			}
+			if ch.Total != len(p.chunks) {
+				mu.Unlock()
+				return errBadChunk
+			}
+			if p.chunks[ch.Index] == nil {
+				if held+len(ch.Data) > maxFragmentBytes {
+					mu.Unlock()
+					return errFragmentLimit
+				}
+				p.chunks[ch.Index] = ch.Data
+				p.received++
+				p.size += len(ch.Data)
+				held += len(ch.Data)
+			}
+			done := p.received == len(p.chunks)
+			if done {
+				held -= p.size
+				delete(partials, ch.ID)
+			}
+			mu.Unlock()
+
+			if !done {
+				return nil
+			}
+			var msg []byte
+			for _, data := range p.chunks {
+				msg = append(msg, data...)
+			}
+			return next(typ, msg)
+		}
+	}
+
+	out := func(next OutboundFunc) OutboundFunc {
+		return func(ctx context.Context, typ MessageType, bts []byte) error {
+			if len(bts) <= maxSize {
+				return next(ctx, typ, bts)
+			}
+			id, err := chunkID()
+			if err != nil {
+				return err
+			}
+			ends, err := chunkEnds(codec, id, bts, maxSize)
+			if err != nil {
+				return err
+			}
+			start := 0
+			for i, end := range ends {
+				chunk, err := codec.Encode(Chunk{ID: id, Index: i, Total: len(ends), Data: bts[start:end]})
+				if err != nil {
+					return err
+				}
+				if err := next(ctx, typ, chunk); err != nil {
+					return err
+				}
+				start = end
+			}
+			return nil
+		}
+	}
+
+	return in, out
+}
+
+var errChunkEnvelope = errors.New("fragmentation size too small for the chunk envelope")
+
+// chunkEnds splits bts in to chunks whose encoding is at most maxSize bytes,
+// returning where each ends. Chunks are sized with an index and total of
+// len(bts), which encode at least as big as the real ones.
+func chunkEnds(codec ChunkCodec, id string, bts []byte, maxSize int) ([]int, error) {
+	fits := func(start, end int) (bool, error) {
+		enc, err := codec.Encode(Chunk{ID: id, Index: len(bts), Total: len(bts), Data: bts[start:end]})
+		return len(enc) <= maxSize, err
+	}
+
+	var ends []int
+	for start := 0; start < len(bts); {
+		// the most data that fits, found by binary search
+		lo, hi := 0, min(len(bts)-start, maxSize)
+		for lo < hi {
+			mid := (lo + hi + 1) / 2
+			ok, err := fits(start, start+mid)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				lo = mid
+			} else {
+				hi = mid - 1
+			}
+		}
+		if lo == 0 {
+			return nil, errChunkEnvelope
+		}
+		start += lo
+		ends = append(ends, start)
+	}
+	return ends, nil
+}
+
+func chunkID() (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
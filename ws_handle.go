@@ -0,0 +1,46 @@
+package apic
+
+import "context"
+
+// Handle is a running client, returned by Run.
+type Handle struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// Run starts the client on its own goroutine, returning a handle to it. The client
+// runs as with Start, until ctx is done, the handle is stopped, or Start returns.
+func (c *WSClient) Run(ctx context.Context) *Handle {
+	ctx, cancel := context.WithCancel(ctx)
+	h := &Handle{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(h.done)
+		defer cancel()
+		h.err = c.Start(ctx)
+	}()
+	return h
+}
+
+// Done returns a channel that is closed once the client has stopped.
+func (h *Handle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Err returns the error Start returned, once Done is closed. Before then, it
+// returns nil.
+func (h *Handle) Err() error {
+	select {
+	case <-h.done:
+		return h.err
+	default:
+		return nil
+	}
+}
+
+// Stop stops the client, and waits for it to stop, returning Start's error.
+func (h *Handle) Stop() error {
+	h.cancel()
+	<-h.done
+	return h.err
+}
@@ -0,0 +1,82 @@
+package apic
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrHeartbeatTimeout = errors.New("websocket heartbeat reply not received")
+
+// heartbeat is an application level heartbeat: payload is sent every interval,
+// and a message matching match is expected back within timeout.
+type heartbeat struct {
+	payload  []byte
+	match    func([]byte) bool
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// heartbeatRun tracks the heartbeat over a single connection. Its zero value,
+// or a nil heartbeat, never fires.
+type heartbeatRun struct {
+	hb     *heartbeat
+	clock  Clock
+	ticker Ticker
+	timer  Timer
+}
+
+func (c *WSClient) startHeartbeat() *heartbeatRun {
+	if c.heartbeat == nil {
+		return &heartbeatRun{}
+	}
+	return &heartbeatRun{hb: c.heartbeat, clock: c.clock, ticker: c.clock.NewTicker(c.heartbeat.interval)}
+}
+
+// tick fires when the next heartbeat is due.
+func (h *heartbeatRun) tick() <-chan time.Time {
+	if h.ticker == nil {
+		return nil
+	}
+	return h.ticker.C()
+}
+
+// missed fires when a reply is overdue.
+func (h *heartbeatRun) missed() <-chan time.Time {
+	if h.timer == nil {
+		return nil
+	}
+	return h.timer.C()
+}
+
+// send writes a heartbeat, starting the reply timeout unless one is already pending.
+func (h *heartbeatRun) send(ctx context.Context, c *WSClient, w *connWriter) error {
+	if err := w.write(ctx, c.messageType, h.hb.payload); err != nil {
+		return err
+	}
+	if h.timer == nil {
+		h.timer = h.clock.NewTimer(h.hb.timeout)
+	}
+	return nil
+}
+
+// reply reports whether msg is a heartbeat reply, clearing the pending timeout if so.
+func (h *heartbeatRun) reply(msg []byte) bool {
+	if h.hb == nil || !h.hb.match(msg) {
+		return false
+	}
+	if h.timer != nil {
+		h.timer.Stop()
+		h.timer = nil
+	}
+	return true
+}
+
+func (h *heartbeatRun) stop() {
+	if h.ticker != nil {
+		h.ticker.Stop()
+	}
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+}
@@ -0,0 +1,199 @@
+package apic
+
+import (
+	"context"
+	"sync"
+)
+
+// topicBuffer is how many published messages a topic holds while its fan-out
+// goroutine catches up.
+const topicBuffer = 64
+
+// Hub is a topic based pub-sub layer over a WSServer. Connections join topics,
+// and messages published to a topic are fanned out to its members by a goroutine
+// per topic. Connections leave their topics when they disconnect.
+type Hub struct {
+	server *WSServer
+
+	mu     sync.Mutex
+	topics map[string]*hubTopic
+	joined map[*WSConn]map[string]struct{}
+}
+
+type hubTopic struct {
+	name    string
+	members map[*WSConn]struct{}
+	msgs    chan []byte
+
+	// done is closed once the topic has no members
+	done chan struct{}
+}
+
+// NewHub creates a hub for the server's connections.
+func NewHub(s *WSServer) *Hub {
+	h := &Hub{
+		server: s,
+		topics: map[string]*hubTopic{},
+		joined: map[*WSConn]map[string]struct{}{},
+	}
+	s.chainOnDisconnect(func(c *WSConn, _ error) {
+		h.LeaveAll(c)
+	})
+	return h
+}
+
+// Join adds c to topic.
+func (h *Hub) Join(c *WSConn, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t, ok := h.topics[topic]
+	if !ok {
+		t = &hubTopic{
+			name:    topic,
+			members: map[*WSConn]struct{}{},
+			msgs:    make(chan []byte, topicBuffer),
+			done:    make(chan struct{}),
+		}
+		h.topics[topic] = t
+		go h.fanOut(t)
+	}
+	t.members[c] = struct{}{}
+	h.server.metrics.TopicConnections(h.server.name, topic, len(t.members))
+
+	if h.joined[c] == nil {
+		h.joined[c] = map[string]struct{}{}
+	}
+	h.joined[c][topic] = struct{}{}
+}
+
+// Leave removes c from topic.
+func (h *Hub) Leave(c *WSConn, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.leave(c, topic)
+}
+
+// LeaveAll removes c from every topic it has joined.
+func (h *Hub) LeaveAll(c *WSConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for topic := range h.joined[c] {
+		h.leave(c, topic)
+	}
+}
+
+// leave must be called with mu held. Topics are closed once their last member leaves.
+func (h *Hub) leave(c *WSConn, topic string) {
+	if topics, ok := h.joined[c]; ok {
+		delete(topics, topic)
+		if len(topics) == 0 {
+			delete(h.joined, c)
+		}
+	}
+	t, ok := h.topics[topic]
+	if !ok {
+		return
+	}
+	delete(t.members, c)
+	h.server.metrics.TopicConnections(h.server.name, topic, len(t.members))
+	if len(t.members) == 0 {
+		delete(h.topics, topic)
+		close(t.done)
+	}
+}
+
+// Publish encodes obj with the server's encoder, and sends it to topic's members.
+// It is a no-op if the topic has no members, and blocks, up to ctx, if the topic's
+// fan-out is behind.
+func (h *Hub) Publish(ctx context.Context, topic string, obj any) error {
+	bts, err := h.server.encoder(obj)
+	if err != nil {
+		return err
+	}
+	return h.PublishBytes(ctx, topic, bts)
+}
+
+// PublishBytes sends already encoded bytes to topic's members. See Publish.
+func (h *Hub) PublishBytes(ctx context.Context, topic string, bts []byte) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	h.mu.Lock()
+	t, ok := h.topics[topic]
+	h.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	select {
+	case t.msgs <- bts:
+		return nil
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Members returns the connections that have joined topic.
+func (h *Hub) Members(topic string) []*WSConn {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t, ok := h.topics[topic]
+	if !ok {
+		return nil
+	}
+	conns := make([]*WSConn, 0, len(t.members))
+	for c := range t.members {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Count returns the number of connections that have joined topic.
+func (h *Hub) Count(topic string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if t, ok := h.topics[topic]; ok {
+		return len(t.members)
+	}
+	return 0
+}
+
+// Topics returns the topics c has joined.
+func (h *Hub) Topics(c *WSConn) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	topics := make([]string, 0, len(h.joined[c]))
+	for topic := range h.joined[c] {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// fanOut sends each message published to t to its members, until t is done.
+func (h *Hub) fanOut(t *hubTopic) {
+	for {
+		var bts []byte
+		select {
+		case bts = <-t.msgs:
+		case <-t.done:
+			return
+		}
+
+		h.mu.Lock()
+		members := make([]*WSConn, 0, len(t.members))
+		for c := range t.members {
+			members = append(members, c)
+		}
+		h.mu.Unlock()
+
+		h.server.metrics.Broadcast(h.server.name, len(members))
+		for _, c := range members {
+			if err := c.Send(c.ctx, bts); err != nil {
+				h.server.logger.Debug("hub: send failed", "topic", t.name, "conn", c.id, "error", err.Error())
+			}
+		}
+	}
+}
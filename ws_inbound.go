@@ -0,0 +1,99 @@
+package apic
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// InboundPolicy decides what the reader does when the inbound buffer is full,
+// because the handler isn't keeping up.
+type InboundPolicy int
+
+const (
+	// InboundBlock stops reading from the connection until the handler catches up.
+	InboundBlock InboundPolicy = iota
+
+	// InboundDropOldest discards the oldest buffered message to make room.
+	InboundDropOldest
+
+	// InboundKeepLatest discards everything buffered, keeping only the newest message.
+	InboundKeepLatest
+)
+
+// DroppedMessages returns how many inbound messages have been dropped by
+// the inbound buffer policy.
+func (c *WSClient) DroppedMessages() uint64 {
+	return c.dropped.Load()
+}
+
+// pushInbound hands msg to the handler loop, via data. It reports false if done
+// closes first, with the handler loop gone.
+func (c *WSClient) pushInbound(data chan wsMessage, msg wsMessage, done <-chan struct{}) bool {
+	if c.inboundPolicy == InboundBlock || cap(data) == 0 {
+		select {
+		case data <- msg:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	for {
+		select {
+		case data <- msg:
+			return true
+		default:
+		}
+
+		// full: make room per the policy, then try again
+		switch c.inboundPolicy {
+		case InboundDropOldest:
+			select {
+			case <-data:
+				c.dropped.Add(1)
+			default:
+			}
+		case InboundKeepLatest:
+			for drained := false; !drained; {
+				select {
+				case <-data:
+					c.dropped.Add(1)
+				default:
+					drained = true
+				}
+			}
+		}
+	}
+}
+
+// readThrottle limits how fast inbound messages are consumed. Waiting happens
+// on the reader, before the next read, so the backpressure lands on the socket.
+type readThrottle struct {
+	messages *rate.Limiter
+	bytes    *rate.Limiter
+}
+
+// wait blocks on clock until a message of n bytes may be consumed.
+func (t *readThrottle) wait(clock Clock, n int) {
+	if t.messages != nil {
+		now := clock.Now()
+		sleep(clock, t.messages.ReserveN(now, 1).DelayFrom(now))
+	}
+	if t.bytes != nil {
+		if b := t.bytes.Burst(); n > b {
+			n = b
+		}
+		now := clock.Now()
+		sleep(clock, t.bytes.ReserveN(now, n).DelayFrom(now))
+	}
+}
+
+func sleep(clock Clock, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t := clock.NewTimer(d)
+	defer t.Stop()
+	<-t.C()
+}
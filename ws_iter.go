@@ -0,0 +1,25 @@
+//go:build go1.23
+
+package apic
+
+import (
+	"context"
+	"iter"
+)
+
+// Iter returns an iterator over inbound message payloads, built on Messages.
+// Iteration ends when the loop breaks, or once ctx is done, in which case the
+// final pair yielded is ctx's error.
+func (c *WSClient) Iter(ctx context.Context) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		sub, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		for msg := range c.Messages(sub) {
+			if !yield(msg.Data, nil) {
+				return
+			}
+		}
+		yield(nil, ctx.Err())
+	}
+}
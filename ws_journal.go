@@ -0,0 +1,29 @@
+package apic
+
+import "fmt"
+
+// journal is a recorder whose frames must be durably written before they are
+// handled.
+type journal struct {
+	recorder
+
+	// sync, if the journal's writer has a Sync method, ie, an *os.File, flushes
+	// each frame to stable storage
+	sync func() error
+}
+
+// journalFrame appends msg to the journal, if there is one.
+func (c *WSClient) journalFrame(msg wsMessage) error {
+	if c.journal == nil {
+		return nil
+	}
+	if err := c.journal.record(msg, c.clock.Now()); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	if c.journal.sync != nil {
+		if err := c.journal.sync(); err != nil {
+			return fmt.Errorf("journal: %w", err)
+		}
+	}
+	return nil
+}
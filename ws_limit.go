@@ -0,0 +1,52 @@
+package apic
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// WriteLimit is the rate, and burst, a class of outbound messages is limited to.
+type WriteLimit struct {
+	Rate  rate.Limit
+	Burst int
+}
+
+// writeLimits picks the limiter for each outbound message.
+type writeLimits struct {
+	classify func([]byte) string
+	classes  map[string]*writeGate
+}
+
+// limiter returns the limiter for msg: its class's, if it has one, otherwise
+// the client wide writeLimiter, which may be nil.
+func (c *WSClient) limiter(msg []byte) *writeGate {
+	if c.writeLimits != nil {
+		if l, ok := c.writeLimits.classes[c.writeLimits.classify(msg)]; ok {
+			return l
+		}
+	}
+	return c.writeLimiter
+}
+
+// waitWrite blocks until msg may be written under the write rate limits, in
+// order of the priority attached to ctx. See WriteWithPriority.
+func (c *WSClient) waitWrite(ctx context.Context, msg []byte) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if l := c.limiter(msg); l != nil {
+		if err := l.wait(ctx); err != nil {
+			return err
+		}
+	}
+	if c.writeBytes != nil {
+		// a message bigger than the burst waits for a full burst's worth
+		n := len(msg)
+		if b := c.writeBytes.Burst(); n > b {
+			n = b
+		}
+		return c.writeBytes.WaitN(ctx, n)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package apic
+
+// logMessage debugs a message with its direction, type, and size, and its
+// body too, after redaction, if body logging is enabled.
+func (c *WSClient) logMessage(msg string, typ MessageType, bts []byte) {
+	args := []any{"type", typ.String(), "size", len(bts)}
+	if c.logBodies {
+		if c.logRedactor != nil {
+			bts = c.logRedactor(bts)
+		}
+		args = append(args, "message", string(bts))
+	}
+	c.logger.Debug(msg, args...)
+}
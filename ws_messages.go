@@ -0,0 +1,75 @@
+package apic
+
+import (
+	"context"
+	"sync"
+)
+
+// WSMessage is a message received by a WSClient.
+type WSMessage struct {
+	Type MessageType
+	Data []byte
+}
+
+// messageSub is a Messages consumer.
+type messageSub struct {
+	ctx context.Context
+	ch  chan WSMessage
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Messages returns a channel receiving every inbound message, as an alternative
+// (or in addition) to the handler. Messages are delivered in order, and the reader
+// waits on the channel, so a slow consumer applies backpressure. The channel is
+// closed once ctx is done.
+func (c *WSClient) Messages(ctx context.Context) <-chan WSMessage {
+	sub := &messageSub{ctx: ctx, ch: make(chan WSMessage)}
+
+	c.mu.Lock()
+	if c.messageSubs == nil {
+		c.messageSubs = map[*messageSub]struct{}{}
+	}
+	c.messageSubs[sub] = struct{}{}
+	c.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		c.mu.Lock()
+		delete(c.messageSubs, sub)
+		c.mu.Unlock()
+
+		sub.mu.Lock()
+		defer sub.mu.Unlock()
+		sub.closed = true
+		close(sub.ch)
+	}()
+	return sub.ch
+}
+
+// publish hands msg to each Messages consumer.
+func (c *WSClient) publish(msg wsMessage) {
+	c.mu.Lock()
+	if len(c.messageSubs) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	subs := make([]*messageSub, 0, len(c.messageSubs))
+	for sub := range c.messageSubs {
+		subs = append(subs, sub)
+	}
+	c.mu.Unlock()
+
+	m := WSMessage{Type: msg.typ, Data: msg.bts}
+	for _, sub := range subs {
+		sub.mu.Lock()
+		if !sub.closed {
+			select {
+			case sub.ch <- m:
+			case <-sub.ctx.Done():
+			}
+		}
+		sub.mu.Unlock()
+	}
+}
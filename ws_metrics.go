@@ -0,0 +1,77 @@
+package apic
+
+import "time"
+
+// WSMetrics receives instrumentation from a WSClient or WSServer. Every method is
+// labeled by the client's endpoint, or the server's name, and maps on to a
+// Prometheus collector:
+//
+//   - MessageReceived, MessageSent: message and byte counters, in and out
+//   - Reconnect: a reconnect counter
+//   - State: a connection state gauge
+//   - HandlerDuration: a handler latency histogram
+//   - QueueDepth: an offline write queue depth gauge
+//
+// A server's metrics may also implement WSServerMetrics.
+//
+// Methods may be called from multiple goroutines.
+type WSMetrics interface {
+	MessageReceived(endpoint string, bytes int)
+	MessageSent(endpoint string, bytes int)
+	Reconnect(endpoint string)
+	State(endpoint string, state ConnState)
+	HandlerDuration(endpoint string, d time.Duration)
+	QueueDepth(endpoint string, depth int)
+}
+
+// WSServerMetrics is WSMetrics with server only instrumentation, reported by a
+// WSServer whose metrics implement it:
+//
+//   - Connections: a current connections gauge
+//   - TopicConnections: a hub topic members gauge, labeled by topic
+//   - Broadcast: a broadcast counter, and recipients counter
+//   - SendQueueOverflow: a slow consumer counter
+//   - HandshakeRejected: a rejected handshake counter, labeled by http status
+type WSServerMetrics interface {
+	WSMetrics
+	Connections(endpoint string, n int)
+	TopicConnections(endpoint, topic string, n int)
+	Broadcast(endpoint string, recipients int)
+	SendQueueOverflow(endpoint string)
+	HandshakeRejected(endpoint string, status int)
+}
+
+// serverMetrics returns m's server instrumentation, discarding it if m doesn't
+// implement WSServerMetrics.
+func serverMetrics(m WSMetrics) WSServerMetrics {
+	if sm, ok := m.(WSServerMetrics); ok {
+		return sm
+	}
+	return clientMetrics{m}
+}
+
+// clientMetrics adapts WSMetrics to WSServerMetrics, ignoring server only
+// instrumentation.
+type clientMetrics struct {
+	WSMetrics
+}
+
+func (clientMetrics) Connections(_ string, _ int)         {}
+func (clientMetrics) TopicConnections(_, _ string, _ int) {}
+func (clientMetrics) Broadcast(_ string, _ int)           {}
+func (clientMetrics) SendQueueOverflow(_ string)          {}
+func (clientMetrics) HandshakeRejected(_ string, _ int)   {}
+
+type noMetrics struct{}
+
+func (noMetrics) MessageReceived(_ string, _ int)           {}
+func (noMetrics) MessageSent(_ string, _ int)               {}
+func (noMetrics) Reconnect(_ string)                        {}
+func (noMetrics) State(_ string, _ ConnState)               {}
+func (noMetrics) HandlerDuration(_ string, _ time.Duration) {}
+func (noMetrics) QueueDepth(_ string, _ int)                {}
+func (noMetrics) Connections(_ string, _ int)               {}
+func (noMetrics) TopicConnections(_, _ string, _ int)       {}
+func (noMetrics) Broadcast(_ string, _ int)                 {}
+func (noMetrics) SendQueueOverflow(_ string)                {}
+func (noMetrics) HandshakeRejected(_ string, _ int)         {}
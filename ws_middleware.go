@@ -0,0 +1,44 @@
+package apic
+
+import "context"
+
+// InboundFunc handles an inbound message.
+type InboundFunc func(typ MessageType, bts []byte) error
+
+// OutboundFunc writes an outbound message.
+type OutboundFunc func(ctx context.Context, typ MessageType, bts []byte) error
+
+// InboundMiddleware wraps the handling of inbound messages. It may transform,
+// drop, or observe messages before calling next.
+type InboundMiddleware func(next InboundFunc) InboundFunc
+
+// OutboundMiddleware wraps the writing of outbound messages. It may transform,
+// reject, or observe messages before calling next. Batched messages pass
+// through it as a single, joined, message.
+type OutboundMiddleware func(next OutboundFunc) OutboundFunc
+
+// inboundHandler is the client's inbound pipeline for a connection, wrapped in
+// the inbound middleware.
+func (c *WSClient) inboundHandler(pool *handlerPool) InboundFunc {
+	fn := func(typ MessageType, bts []byte) error {
+		return c.receive(wsMessage{typ: typ, bts: bts}, pool)
+	}
+	for i := len(c.inbound) - 1; i >= 0; i-- {
+		fn = c.inbound[i](fn)
+	}
+	return fn
+}
+
+// send writes a message through the outbound middleware.
+func (c *WSClient) send(ctx context.Context, typ MessageType, bts []byte) error {
+	return c.outboundFn(ctx, typ, bts)
+}
+
+// buildOutbound wraps transmit in the outbound middleware.
+func (c *WSClient) buildOutbound() {
+	fn := OutboundFunc(c.transmit)
+	for i := len(c.outbound) - 1; i >= 0; i-- {
+		fn = c.outbound[i](fn)
+	}
+	c.outboundFn = fn
+}
@@ -0,0 +1,166 @@
+package apic
+
+import (
+	"context"
+	"sync"
+)
+
+// SubscriptionMuxConfig configures a SubscriptionMux for a particular API.
+type SubscriptionMuxConfig struct {
+	// Subscribe and Unsubscribe build the messages subscribing to, and
+	// unsubscribing from, an upstream channel. They are encoded with the
+	// client's encoder.
+	Subscribe   func(channel string) any
+	Unsubscribe func(channel string) any
+
+	// Channel returns the channel an inbound message belongs to, if any.
+	Channel func(msg []byte) (channel string, ok bool)
+
+	// Unrouted, if set, handles messages that don't belong to a subscribed channel.
+	Unrouted func(msg []byte) error
+}
+
+// SubscriptionMux lets several consumers share upstream channel subscriptions
+// over one WSClient. The upstream subscribe is sent when a channel gains its first
+// consumer, and the unsubscribe when it loses its last. Subscriptions are re-sent
+// on each new connection.
+type SubscriptionMux struct {
+	ws  *WSClient
+	cfg SubscriptionMuxConfig
+
+	mu        sync.Mutex
+	connected bool
+	seq       uint64
+	channels  map[string]map[uint64]func([]byte) error
+}
+
+// NewSubscriptionMux creates a subscription mux for the endpoint. The options are
+// passed along to the underlying WSClient, except for the global message handler,
+// which the mux owns.
+func NewSubscriptionMux(endpoint string, cfg SubscriptionMuxConfig, opts ...WSOption) *SubscriptionMux {
+	m := &SubscriptionMux{
+		cfg:      cfg,
+		channels: map[string]map[uint64]func([]byte) error{},
+	}
+	opts = append(append([]WSOption{}, opts...), WithWSHandler(m.handle))
+	m.ws = NewWSClient(endpoint, opts...)
+	m.ws.chainOnOpen(m.onOpen)
+	m.ws.chainOnClose(m.onClose)
+	return m
+}
+
+// WS returns the underlying websocket client.
+func (m *SubscriptionMux) WS() *WSClient {
+	return m.ws
+}
+
+// Start runs the underlying websocket client. See WSClient.Start.
+func (m *SubscriptionMux) Start(ctx context.Context) error {
+	return m.ws.Start(ctx)
+}
+
+// Subscribe adds fn as a consumer of channel. The returned func removes it.
+func (m *SubscriptionMux) Subscribe(ctx context.Context, channel string, fn func([]byte) error) (func(context.Context) error, error) {
+	m.mu.Lock()
+	m.seq++
+	id := m.seq
+	consumers, ok := m.channels[channel]
+	if !ok {
+		consumers = map[uint64]func([]byte) error{}
+		m.channels[channel] = consumers
+	}
+	consumers[id] = fn
+	send := !ok && m.connected
+	m.mu.Unlock()
+
+	unsubscribe := func(ctx context.Context) error {
+		return m.unsubscribe(ctx, channel, id)
+	}
+	if !send {
+		return unsubscribe, nil
+	}
+	if err := m.ws.Write(ctx, m.cfg.Subscribe(channel)); err != nil {
+		return unsubscribe, err
+	}
+	return unsubscribe, nil
+}
+
+// Consumers returns the number of consumers of channel.
+func (m *SubscriptionMux) Consumers(channel string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.channels[channel])
+}
+
+func (m *SubscriptionMux) unsubscribe(ctx context.Context, channel string, id uint64) error {
+	m.mu.Lock()
+	consumers, ok := m.channels[channel]
+	if !ok {
+		m.mu.Unlock()
+		return nil
+	}
+	delete(consumers, id)
+	last := len(consumers) == 0
+	if last {
+		delete(m.channels, channel)
+	}
+	send := last && m.connected
+	m.mu.Unlock()
+
+	if !send {
+		return nil
+	}
+	return m.ws.Write(ctx, m.cfg.Unsubscribe(channel))
+}
+
+func (m *SubscriptionMux) onOpen(ws *WSClient) error {
+	m.mu.Lock()
+	m.connected = true
+	channels := make([]string, 0, len(m.channels))
+	for ch := range m.channels {
+		channels = append(channels, ch)
+	}
+	m.mu.Unlock()
+
+	for _, ch := range channels {
+		if err := ws.Write(context.Background(), m.cfg.Subscribe(ch)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *SubscriptionMux) onClose(_ *WSClient) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connected = false
+	return nil
+}
+
+func (m *SubscriptionMux) handle(bts []byte) error {
+	channel, ok := m.cfg.Channel(bts)
+
+	var consumers []func([]byte) error
+	if ok {
+		m.mu.Lock()
+		for _, fn := range m.channels[channel] {
+			consumers = append(consumers, fn)
+		}
+		m.mu.Unlock()
+	}
+
+	if len(consumers) == 0 {
+		if m.cfg.Unrouted != nil {
+			return m.cfg.Unrouted(bts)
+		}
+		m.ws.logger.Debug("mux: no consumers for message", "channel", channel)
+		return nil
+	}
+
+	for _, fn := range consumers {
+		if err := fn(bts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
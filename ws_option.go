@@ -1,9 +1,14 @@
 package apic
 
 import (
-	"math/rand"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
 	"time"
 
+	"golang.org/x/time/rate"
 	"nhooyr.io/websocket"
 )
 
@@ -16,20 +21,220 @@ func WithWSLogger(l Logger) WSOption {
 	}
 }
 
-// WithPingInterval sets the ping interval
+// WithWSLogBodies includes message bodies in the debug logs of each message sent
+// and received, which otherwise only log the message type and size.
+func WithWSLogBodies() WSOption {
+	return func(c *WSClient) {
+		c.logBodies = true
+	}
+}
+
+// WithWSLogRedactor passes message bodies through fn before they are logged, ie, to
+// mask tokens in auth frames. fn must not modify its argument in place, and is only
+// used with WithWSLogBodies.
+func WithWSLogRedactor(fn func([]byte) []byte) WSOption {
+	return func(c *WSClient) {
+		c.logRedactor = fn
+	}
+}
+
+// WithWSMetrics sets the metrics the client reports to. See WSMetrics.
+func WithWSMetrics(m WSMetrics) WSOption {
+	return func(c *WSClient) {
+		c.metrics = m
+	}
+}
+
+// WithWSOTel traces the client with tracer. A "ws.dial" span covers each dial and
+// handshake, and a "ws.session" span each connection, ending with its close code.
+// Each inbound and outbound message also gets a "ws.receive" or "ws.send" span with
+// probability sampleRate, which may be 0.
+func WithWSOTel(tracer WSTracer, sampleRate float64) WSOption {
+	return func(c *WSClient) {
+		c.tracer = tracer
+		c.traceSampleRate = sampleRate
+	}
+}
+
+// WithPingInterval sets the ping interval. A ping not answered within the interval
+// fails the connection with ErrPongTimeout.
 func WithPingInterval(i time.Duration) WSOption {
 	return func(c *WSClient) {
 		c.pingInterval = i
 	}
 }
 
+// WithWSOnRTT sets a callback invoked with the round trip time of each ping.
+// See WithPingInterval and LastRTT.
+func WithWSOnRTT(fn func(rtt time.Duration)) WSOption {
+	return func(c *WSClient) {
+		c.onRTT = fn
+	}
+}
+
+// WithHeartbeat sends payload every interval, as an application level heartbeat.
+// Inbound messages for which isReply returns true are taken as replies, and are not
+// passed to the handler. If no reply arrives within timeout of a heartbeat, the
+// connection is closed with ErrHeartbeatTimeout. An interval of zero or less
+// disables the heartbeat, and a timeout of zero or less defaults to interval.
+func WithHeartbeat(payload []byte, isReply func([]byte) bool, interval, timeout time.Duration) WSOption {
+	return func(c *WSClient) {
+		if interval <= 0 {
+			c.heartbeat = nil
+			return
+		}
+		if timeout <= 0 {
+			timeout = interval
+		}
+		c.heartbeat = &heartbeat{
+			payload:  payload,
+			match:    isReply,
+			interval: interval,
+			timeout:  timeout,
+		}
+	}
+}
+
 // WithWSHandler sets the global message handler for the client.
 func WithWSHandler(fn func([]byte) error) WSOption {
+	return func(c *WSClient) {
+		c.handler = func(_ MessageType, bts []byte) error { return fn(bts) }
+	}
+}
+
+// WithWSMessageHandler sets the global message handler for the client, passing
+// along whether each message was a text or binary frame.
+func WithWSMessageHandler(fn func(MessageType, []byte) error) WSOption {
 	return func(c *WSClient) {
 		c.handler = fn
 	}
 }
 
+// WithWSReplyHandler sets the global message handler for the client, writing any
+// non-nil bytes fn returns back to the server, as the same frame type as the message
+// being replied to. A failed reply is treated like a handler error.
+func WithWSReplyHandler(fn func([]byte) ([]byte, error)) WSOption {
+	return func(c *WSClient) {
+		c.handler = func(typ MessageType, bts []byte) error {
+			reply, err := fn(bts)
+			if err != nil || reply == nil {
+				return err
+			}
+			return c.writeFrame(context.Background(), typ, reply)
+		}
+	}
+}
+
+// WithWSTypedHandler sets the global message handler for the client, decoding each
+// message in to a T with the client's decoder (see WithWSDecoder) before calling fn.
+// A decode error is treated like a handler error.
+func WithWSTypedHandler[T any](fn func(T) error) WSOption {
+	return func(c *WSClient) {
+		c.handler = func(_ MessageType, bts []byte) error {
+			var v T
+			if err := c.decoder(bts, &v); err != nil {
+				return err
+			}
+			return fn(v)
+		}
+	}
+}
+
+// WithWSFilter drops inbound messages keep returns false for before they are decoded
+// or handled, ie, symbols or heartbeats of no interest on a high volume feed. It sees
+// messages after inbound middleware, sequence tracking, and dedup, and call responses
+// never reach it.
+func WithWSFilter(keep func([]byte) bool) WSOption {
+	return func(c *WSClient) {
+		c.filter = keep
+	}
+}
+
+// WithWSMiddleware adds inbound and outbound message middleware, either of which
+// may be nil. Middleware added first runs outermost. Inbound middleware sees every
+// message read, ahead of call responses being matched and the handler; outbound
+// middleware every message written, ahead of the offline queue and the connection.
+func WithWSMiddleware(inbound InboundMiddleware, outbound OutboundMiddleware) WSOption {
+	return func(c *WSClient) {
+		if inbound != nil {
+			c.inbound = append(c.inbound, inbound)
+		}
+		if outbound != nil {
+			c.outbound = append(c.outbound, outbound)
+		}
+	}
+}
+
+// WithPayloadTransform transforms message payloads at the application layer, ie, for
+// providers that compress message bodies themselves (see Gzip and Deflate). outbound
+// is applied to each message written, and inbound to each message read; either may be
+// nil. The transforms run as middleware, added as if by WithWSMiddleware.
+func WithPayloadTransform(outbound, inbound PayloadTransform) WSOption {
+	return WithWSMiddleware(transformMiddleware(outbound, inbound))
+}
+
+// WithFragmentation splits outbound messages over maxSize bytes in to chunks, sent
+// in codec's envelope, and reassembles inbound chunks in to whole messages before
+// they are handled, for brokers with small frame size caps. maxSize bounds each
+// encoded chunk, envelope included, and inbound chunks with more data are rejected.
+// A maxSize under 1 disables fragmentation.
+// Reassembly is bounded against misbehaving peers: at most 65536 chunks a message,
+// 1024 partial messages, and 64MiB held across them. The fragmentation runs as
+// middleware, added as if by WithWSMiddleware.
+func WithFragmentation(maxSize int, codec ChunkCodec) WSOption {
+	if maxSize < 1 {
+		return func(*WSClient) {}
+	}
+	return func(c *WSClient) {
+		// the clock is read per message, as WithClock may come after
+		WithWSMiddleware(fragmentMiddleware(maxSize, codec, func() time.Time { return c.clock.Now() }))(c)
+	}
+}
+
+// WithFrameSplitter splits each inbound frame in to the messages split returns, for
+// feeds that pack several messages in to a frame (see SplitLines and SplitJSONArray),
+// so that the handler is called once per message. The splitting runs as middleware,
+// added as if by WithWSMiddleware.
+func WithFrameSplitter(split func([]byte) [][]byte) WSOption {
+	return WithWSMiddleware(splitMiddleware(split), nil)
+}
+
+// WithFrameParser parses each inbound frame with parse before it is handled (see
+// FrameParser). A parse error is treated like a handler error. The parser runs as
+// middleware, added as if by WithWSMiddleware, so a frame splitter added ahead of
+// it parses each message split out of a frame.
+func WithFrameParser(parse FrameParser) WSOption {
+	return WithWSMiddleware(transformMiddleware(nil, PayloadTransform(parse)))
+}
+
+// WithWSCodec encodes and decodes messages with codec, written as text frames for
+// text content types, ie, json and xml, and binary frames otherwise.
+func WithWSCodec(codec Codec) WSOption {
+	return func(c *WSClient) {
+		c.encoder = codec.Encode
+		c.decoder = codec.Decode
+		c.messageType = messageTypeFor(codec.ContentType())
+	}
+}
+
+// WithWSMsgpack encodes and decodes messages as MessagePack, written as binary
+// frames.
+func WithWSMsgpack() WSOption {
+	return WithWSCodec(MsgpackCodec)
+}
+
+// WithWSCBOR encodes and decodes messages as CBOR, written as binary frames.
+func WithWSCBOR() WSOption {
+	return WithWSCodec(CBORCodec)
+}
+
+// WithMessageType sets the frame type used by Write and Send. Defaults to MessageText.
+func WithMessageType(typ MessageType) WSOption {
+	return func(c *WSClient) {
+		c.messageType = typ
+	}
+}
+
 // WithWSOnOpen sets the callback called whenver a new connection is opened.
 func WithWSOnOpen(fn func(*WSClient) error) WSOption {
 	return func(c *WSClient) {
@@ -44,6 +249,24 @@ func WithWSOnClose(fn func(*WSClient) error) WSOption {
 	}
 }
 
+// WithWSOnError sets the callback called for each error the client encounters. fatal
+// is true for errors that terminated the connection (dial, read, ping, handler errors and
+// stale detection), and false for errors the connection survived.
+func WithWSOnError(fn func(err error, fatal bool)) WSOption {
+	return func(c *WSClient) {
+		c.onError = fn
+	}
+}
+
+// WithOnReconnect sets the callback called just before each reconnect attempt, with the
+// attempt number since the last successful connection, the error that caused the
+// disconnect, and how long the client has been disconnected.
+func WithOnReconnect(fn func(attempt int, lastErr error, downtime time.Duration)) WSOption {
+	return func(c *WSClient) {
+		c.onReconnect = fn
+	}
+}
+
 // WithWSEncoder sets the encoder for objects written to the client
 func WithWSEncoder(fn func(any) ([]byte, error)) WSOption {
 	return func(c *WSClient) {
@@ -51,29 +274,56 @@ func WithWSEncoder(fn func(any) ([]byte, error)) WSOption {
 	}
 }
 
-// WithReconnect enables exponential backoff behavior on reconnect.
+// WithWSDecoder sets the decoder for messages received by the client, used by
+// WithWSTypedHandler and Decode. Defaults to json.Unmarshal.
+func WithWSDecoder(fn func([]byte, any) error) WSOption {
+	return func(c *WSClient) {
+		c.decoder = fn
+	}
+}
+
+// WithWSStrictJSON decodes json messages with unknown fields disallowed.
+func WithWSStrictJSON() WSOption {
+	return WithWSDecoder(strictJSONDecoder)
+}
+
+// WithReconnectPolicy sets the policy deciding whether, and when, to reconnect.
+func WithReconnectPolicy(p ReconnectPolicy) WSOption {
+	return func(c *WSClient) {
+		c.reconnectPolicy = p
+	}
+}
+
+// WithReconnectBackoff enables reconnecting with exponential backoff and full jitter,
+// with delays capped at maxBackoff. See Backoff.
 func WithReconnectBackoff(maxBackoff time.Duration) WSOption {
 	return func(c *WSClient) {
-		const (
-			minMillis = 5
-			maxMillis = 999
-		)
-		var (
-			count int
-		)
-		c.shouldReconnect = func(err error) bool {
-			count++
-			mills := rand.Intn(maxMillis-minMillis) + minMillis
-			d := time.Millisecond * time.Duration((16^count)+mills)
-			if d > maxBackoff {
-				d = maxBackoff
-			}
-			t := time.NewTicker(d)
-			c.logger.Info("reconnect backoff", "duration", d.String())
-			<-t.C
-			t.Stop()
-			return true
-		}
+		c.reconnectPolicy = Backoff{Max: maxBackoff}
+	}
+}
+
+// WithMaxReconnectDuration makes the client give up, returning a *ReconnectDurationError,
+// once it has been continuously unable to reconnect for longer than d.
+func WithMaxReconnectDuration(d time.Duration) WSOption {
+	return func(c *WSClient) {
+		c.maxReconnectDuration = d
+	}
+}
+
+// WithMaxAttempts makes the client give up, returning a *MaxAttemptsError, after n
+// consecutive reconnect attempts have failed.
+func WithMaxAttempts(n int) WSOption {
+	return func(c *WSClient) {
+		c.maxAttempts = n
+	}
+}
+
+// WithAttemptResetAfter makes the reconnect attempt count (and the downtime tracked for
+// WithMaxReconnectDuration) reset only once a connection has stayed up for d, rather than
+// as soon as it connects, so that a flapping endpoint still runs out of attempts.
+func WithAttemptResetAfter(d time.Duration) WSOption {
+	return func(c *WSClient) {
+		c.attemptResetAfter = d
 	}
 }
 
@@ -86,6 +336,130 @@ func WithDialOptions(fn func() (*DialOptions, error)) WSOption {
 	}
 }
 
+// WithWSTokenSource authorizes each dial with a token from source, placed per
+// placement. Tokens are reused across reconnects until they are within refresh of
+// their expiry, at which point a new one is fetched ahead of the next dial.
+func WithWSTokenSource(source TokenSource, placement TokenPlacement, refresh time.Duration) WSOption {
+	return func(c *WSClient) {
+		c.token = &wsToken{source: source, placement: placement, refresh: refresh}
+	}
+}
+
+// WithEndpoints dials primary, failing over to each of the backups in turn when a
+// dial fails. While connected to a backup, the primary is probed every failback
+// interval (see WithFailbackInterval), and the client reconnects to it once it
+// accepts connections again. Failing over happens on reconnect, so it needs a
+// reconnect policy that retries. The endpoint passed to NewWSClient is ignored.
+func WithEndpoints(primary string, backups ...string) WSOption {
+	return func(c *WSClient) {
+		c.endpoints = &failoverEndpoints{endpoints: append([]string{primary}, backups...)}
+	}
+}
+
+// WithRoundRobinEndpoints spreads connections across equivalent endpoints, such as
+// regional gateways, moving on to the next one for each dial. An endpoint that fails
+// to dial is skipped for a cooldown that grows with each consecutive failure. The
+// endpoint passed to NewWSClient is ignored.
+func WithRoundRobinEndpoints(endpoints ...string) WSOption {
+	return func(c *WSClient) {
+		c.endpoints = newRoundRobinEndpoints(endpoints)
+	}
+}
+
+// WithFailbackInterval sets how often the primary endpoint is probed while connected
+// to a backup. The default is 30 seconds, and zero disables failing back.
+func WithFailbackInterval(d time.Duration) WSOption {
+	return func(c *WSClient) {
+		c.failbackInterval = d
+	}
+}
+
+// WithSessionResume asks the server to replay messages missed while disconnected.
+// On each reconnect, getResumeToken is called for the token identifying the last
+// message processed (ie, a last event id), and unless it is "", applyToDial adds it
+// to the endpoint, which it returns, or the dial options' headers.
+func WithSessionResume(getResumeToken func() string, applyToDial func(token, endpoint string, opts *DialOptions) string) WSOption {
+	return func(c *WSClient) {
+		c.sessionResume = &sessionResume{token: getResumeToken, apply: applyToDial}
+	}
+}
+
+// WithWSProxy dials through the proxy at proxyURL, which may be an http, https,
+// or socks5 url. wss endpoints are tunneled through http(s) proxies with CONNECT.
+func WithWSProxy(proxyURL string) WSOption {
+	return func(c *WSClient) {
+		c.transportConfig().proxy = proxyURL
+	}
+}
+
+// WithNetDialer dials connections with d, ie, to bind a local address on a multi-homed
+// host, or use a custom resolver. With a proxy, d dials the proxy.
+func WithNetDialer(d *net.Dialer) WSOption {
+	return func(c *WSClient) {
+		c.transportConfig().dialer = d
+	}
+}
+
+// WithWSTLSConfig dials wss endpoints with cfg, ie, for client certificates, custom
+// roots, or certificate pinning with VerifyPeerCertificate.
+func WithWSTLSConfig(cfg *tls.Config) WSOption {
+	return func(c *WSClient) {
+		c.transportConfig().tls = cfg
+	}
+}
+
+// WithRecorder captures every inbound frame, with the time it was received, to w as
+// json lines, for replaying with Replay.
+func WithRecorder(w io.Writer) WSOption {
+	return func(c *WSClient) {
+		c.recorder = &recorder{enc: json.NewEncoder(w)}
+	}
+}
+
+// WithJournal appends every inbound frame, with the time it was received, to w
+// before it is handled, in the format of WithRecorder, so that the journal can be
+// reprocessed with Replay after a handler bug. Unlike a recording, the journal is
+// durable: if w has a Sync method, as an *os.File does, it is synced after each
+// frame, and a frame that can't be journaled ends the connection unhandled.
+func WithJournal(w io.Writer) WSOption {
+	return func(c *WSClient) {
+		j := &journal{recorder: recorder{enc: json.NewEncoder(w)}}
+		if s, ok := w.(interface{ Sync() error }); ok {
+			j.sync = s.Sync
+		}
+		c.journal = j
+	}
+}
+
+// WithReplaySource has the client replay the frames journaled with WithJournal, or
+// recorded with WithRecorder, from r when started, in place of connecting, for
+// backtesting. The onOpen and onClose callbacks run around the replay, messages
+// written are discarded, and Start returns once r is exhausted. speed is as for
+// Replay. It lets protocol clients built on a WSClient be replayed, too.
+func WithReplaySource(r io.Reader, speed float64) WSOption {
+	return func(c *WSClient) {
+		c.replay = &replaySource{r: r, speed: speed}
+	}
+}
+
+// WithChaos randomly injects faults, per cfg, for soak testing reconnect and dedup
+// logic. Never enable it in production.
+func WithChaos(cfg ChaosConfig) WSOption {
+	return func(c *WSClient) {
+		c.chaos = &cfg
+	}
+}
+
+// WithClock sets the clock the client, and the protocol clients over it, keep time
+// with: stale detection, pings, heartbeats, reconnect backoff, and timeouts other
+// than the write timeout, which bounds real io. Defaults to the system clock; tests
+// can use a fake one.
+func WithClock(clk Clock) WSOption {
+	return func(c *WSClient) {
+		c.clock = clk
+	}
+}
+
 // WithStaleDetection, if configured, will create a goroutine that asserts on the websocket
 // having received some message within some recent time interval. If the assertion fails, the connection
 // is closed, and whatever reconnect behavior has been configured will take over.
@@ -94,3 +468,255 @@ func WithStaleDetection(timeout time.Duration) WSOption {
 		c.staleMessageTimeout = timeout
 	}
 }
+
+// WithStaleClassTimeouts gives classes of inbound messages, as named by classify,
+// stale timeouts of their own, ie, for a heartbeat expected every few seconds on a
+// feed whose trades may pause for hours. The connection is closed as stale once any
+// class in timeouts goes longer than its timeout without a message, timed from the
+// connection opening until the first. It works alongside WithStaleDetection.
+func WithStaleClassTimeouts(classify func([]byte) string, timeouts map[string]time.Duration) WSOption {
+	return func(c *WSClient) {
+		c.staleClasses = &staleClasses{classify: classify, timeouts: timeouts}
+	}
+}
+
+// StaleMode decides what counts as a sign of life for stale detection.
+type StaleMode int
+
+const (
+	// StaleOnMessage judges liveness by inbound messages only.
+	StaleOnMessage StaleMode = iota
+
+	// StaleOnPong also counts pong responses, so that quiet connections
+	// aren't closed for want of messages. It requires WithPingInterval,
+	// with an interval shorter than the stale timeout.
+	StaleOnPong
+)
+
+// WithStaleDetectionMode sets what stale detection counts as a sign of life.
+// The default is StaleOnMessage.
+func WithStaleDetectionMode(mode StaleMode) WSOption {
+	return func(c *WSClient) {
+		c.staleMode = mode
+	}
+}
+
+// WithCallIDs sets how Call attaches correlation ids to requests, and how they're
+// read back out of responses. By default, an "id" field is set on the json request
+// object and read from json response objects, with ids counting up from "1".
+func WithCallIDs(attach CallIDAttacher, extract CallIDExtractor) WSOption {
+	return func(c *WSClient) {
+		c.callAttach = attach
+		c.callExtract = extract
+	}
+}
+
+// WithCallTimeout sets how long Call waits for a response.
+func WithCallTimeout(d time.Duration) WSOption {
+	return func(c *WSClient) {
+		c.callTimeout = d
+	}
+}
+
+// WithWriteTimeout sets a deadline for each message written to the connection. A write
+// that exceeds the deadline fails, and the underlying connection is closed, handing
+// control to the reconnect policy.
+func WithWriteTimeout(d time.Duration) WSOption {
+	return func(c *WSClient) {
+		c.writeTimeout = d
+	}
+}
+
+// CompressionMode is a permessage-deflate compression mode.
+type CompressionMode = websocket.CompressionMode
+
+const (
+	CompressionDisabled          = websocket.CompressionDisabled
+	CompressionContextTakeover   = websocket.CompressionContextTakeover
+	CompressionNoContextTakeover = websocket.CompressionNoContextTakeover
+)
+
+type compressionConfig struct {
+	mode      CompressionMode
+	threshold int
+}
+
+// WithWriteRateLimit limits the rate of outbound messages, with the given burst.
+func WithWriteRateLimit(r rate.Limit, b int) WSOption {
+	return func(c *WSClient) {
+		c.writeLimiter = newWriteGate(rate.NewLimiter(r, b))
+	}
+}
+
+// WithWriteByteRateLimit limits outbound messages to bytesPerSec bytes a second,
+// alongside any message rate limits, for servers that limit bandwidth. A message
+// bigger than bytesPerSec waits for a whole second's allowance.
+func WithWriteByteRateLimit(bytesPerSec int) WSOption {
+	return func(c *WSClient) {
+		c.writeBytes = rate.NewLimiter(rate.Limit(bytesPerSec), bytesPerSec)
+	}
+}
+
+// WithWriteClassLimits gives classes of outbound messages, as named by classify, rate
+// limits of their own, so that one class of traffic can't starve another. Messages in
+// a class without a limit fall under WithWriteRateLimit.
+func WithWriteClassLimits(classify func([]byte) string, limits map[string]WriteLimit) WSOption {
+	return func(c *WSClient) {
+		wl := &writeLimits{classify: classify, classes: map[string]*writeGate{}}
+		for class, l := range limits {
+			wl.classes[class] = newWriteGate(rate.NewLimiter(l.Rate, l.Burst))
+		}
+		c.writeLimits = wl
+	}
+}
+
+// WithCompression negotiates permessage-deflate compression with the server. Messages
+// smaller than threshold bytes are sent uncompressed; zero uses the library default
+// for the mode. Overrides any compression settings from WithDialOptions.
+func WithCompression(mode CompressionMode, threshold int) WSOption {
+	return func(c *WSClient) {
+		c.compression = &compressionConfig{mode: mode, threshold: threshold}
+	}
+}
+
+// WithSubprotocols requests the given subprotocols when dialing, in addition to any
+// set by WithDialOptions. The negotiated subprotocol is available from Subprotocol.
+func WithSubprotocols(protos ...string) WSOption {
+	return func(c *WSClient) {
+		c.subprotocols = append(c.subprotocols, protos...)
+	}
+}
+
+// WithOfflineQueue makes writes made while disconnected queue up, rather than fail
+// with ErrNotConnected. Up to size messages are held, with policy deciding what
+// happens when the queue is full; a size under 1 holds none. Queued messages are
+// written in order once the next connection is open, after the onOpen callback.
+// Those that fail to write are queued again, ahead of newer ones, within size:
+// QueueDropOldest drops the oldest of them, and the other policies the newest.
+func WithOfflineQueue(size int, policy QueueOverflowPolicy) WSOption {
+	return func(c *WSClient) {
+		c.queue = &writeQueue{size: size, policy: policy}
+	}
+}
+
+// WithOutbox durably stores messages written while disconnected in o, writing them
+// once connected, in place of the offline queue. See Outbox.
+func WithOutbox(o *Outbox) WSOption {
+	return func(c *WSClient) {
+		c.outbox = o
+	}
+}
+
+// WithOutboxAcks keeps messages in the outbox, written while connected or not,
+// until the server acknowledges them, writing those still unacknowledged to each
+// new connection. id names each outbound message, with "" for one needing no
+// ack, and acked names the message an inbound one acknowledges, if it is an ack.
+// Inbound acks are still handled as usual. It requires WithOutbox.
+func WithOutboxAcks(id func(outbound []byte) string, acked func(inbound []byte) (id string, ok bool)) WSOption {
+	return func(c *WSClient) {
+		c.outboxAcks = &outboxAcks{id: id, acked: acked}
+	}
+}
+
+// WithWriteBatching coalesces messages passed to Write and Send in to single frames
+// of up to maxMessages, flushed at least every flushInterval. Each write blocks until
+// its batch has been written, which is bounded by the earliest deadline among the
+// batch's writes, or 10 seconds if none has one. Batches are json arrays, unless
+// changed by WithBatchFormat; binary messages aren't batched as arrays.
+func WithWriteBatching(maxMessages int, flushInterval time.Duration) WSOption {
+	return func(c *WSClient) {
+		c.batcher = &writeBatcher{max: maxMessages, interval: flushInterval}
+	}
+}
+
+// WithBatchFormat sets how batched messages are joined, when write batching is enabled.
+func WithBatchFormat(format BatchFormat) WSOption {
+	return func(c *WSClient) {
+		c.batchFormat = format
+	}
+}
+
+// WithInboundBuffer buffers up to n inbound messages between the connection and the
+// handler, with policy deciding what happens when a slow handler lets the buffer fill.
+// Messages dropped by the policy are counted by DroppedMessages.
+func WithInboundBuffer(n int, policy InboundPolicy) WSOption {
+	return func(c *WSClient) {
+		c.inboundBuffer = n
+		c.inboundPolicy = policy
+	}
+}
+
+// WithReadRateLimit throttles how fast inbound messages are consumed, to at most
+// messagesPerSec messages and bytesPerSec bytes a second; zero leaves either unlimited.
+// Rather than buffering, the client stops reading from the socket while throttled,
+// so the server sees the backpressure.
+func WithReadRateLimit(messagesPerSec rate.Limit, bytesPerSec int) WSOption {
+	return func(c *WSClient) {
+		t := &readThrottle{}
+		if messagesPerSec != 0 {
+			t.messages = rate.NewLimiter(messagesPerSec, 1)
+		}
+		if bytesPerSec != 0 {
+			t.bytes = rate.NewLimiter(rate.Limit(bytesPerSec), bytesPerSec)
+		}
+		c.readThrottle = t
+	}
+}
+
+// WithPausePolicy sets what happens to messages received while the client is
+// paused (see Pause), and for PauseBuffer, how many are held, with zero holding
+// them all. The default is PauseBuffer, holding them all.
+func WithPausePolicy(policy PausePolicy, size int) WSOption {
+	return func(c *WSClient) {
+		c.pause.policy = policy
+		c.pause.size = size
+	}
+}
+
+// WithHandlerConcurrency runs the handler on n worker goroutines, rather than serially
+// on the read loop. If key is nil, messages are handled in no particular order. Otherwise,
+// messages with the same key are always handled in order, by the same worker (see
+// OrderByJSONField). The first handler error terminates the connection.
+func WithHandlerConcurrency(n int, key func([]byte) string) WSOption {
+	return func(c *WSClient) {
+		c.handlerConcurrency = n
+		c.orderingKey = key
+	}
+}
+
+// WithSequenceTracking detects gaps in inbound sequence numbers, as read by extractSeq,
+// calling onGap with the missing range (inclusive) before the message after the gap is
+// handled, ie, to resync from a REST snapshot. Sequence numbers are tracked across
+// reconnects, so gaps while disconnected are caught too. An error from onGap is
+// treated like a handler error.
+func WithSequenceTracking(extractSeq func([]byte) (uint64, bool), onGap func(from, to uint64) error) WSOption {
+	return func(c *WSClient) {
+		c.sequence = &sequenceTracker{extract: extractSeq, onGap: onGap}
+	}
+}
+
+// WithDedup drops inbound messages whose id, as read by extractID, was already seen
+// within window. Messages for which extractID returns "" are always handled.
+func WithDedup(extractID func([]byte) string, window time.Duration) WSOption {
+	return func(c *WSClient) {
+		c.dedup = &dedupWindow{extract: extractID, window: window, seen: map[string]time.Time{}}
+	}
+}
+
+// WithAcks acknowledges inbound messages with frames built by encoder. A message is
+// acked once the handler returns nil. A handler returning ErrNack has the message
+// redelivered, up to maxRedeliveries times, before it is nacked. A handler returning
+// ErrAckLater acks the message itself, with Ack or Nack.
+func WithAcks(encoder AckEncoder, maxRedeliveries int) WSOption {
+	return func(c *WSClient) {
+		c.acks = &acks{encoder: encoder, maxRedeliveries: maxRedeliveries}
+	}
+}
+
+// WithoutPanicRecovery disables recovering from handler panics, which are otherwise
+// converted to a *PanicError and passed to the reconnect policy. Useful for debugging.
+func WithoutPanicRecovery() WSOption {
+	return func(c *WSClient) {
+		c.disablePanicRecovery = true
+	}
+}
@@ -0,0 +1,339 @@
+package apic
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+)
+
+// outboxHeader is the size of each outbox record's header: the message type,
+// then the payload length.
+const outboxHeader = 5
+
+// Outbox is a file backed queue of outbound messages, for at-least-once delivery
+// over an intermittent connection: with WithOutbox, messages written while
+// disconnected, or whose write failed with the connection, are appended to the file,
+// and written to the next connection, even one made by a later process. Messages
+// leave the outbox only once written, or with WithOutboxAcks, once acknowledged,
+// and the file is compacted as they do, so a crash part way through writing them
+// out has only those since the last compaction written again.
+type Outbox struct {
+	path string
+
+	mu      sync.Mutex
+	file    *os.File
+	entries []*outboxEntry
+
+	// flushing is set while the outbox is being written to a new connection,
+	// so that concurrent writes append behind it rather than jumping ahead.
+	flushing bool
+}
+
+// outboxEntry is a message in the outbox. sent is set once it has been written
+// to the current connection, and done once it can leave the outbox.
+type outboxEntry struct {
+	msg  wsMessage
+	id   string
+	sent bool
+	done bool
+}
+
+// outboxAcks tells which outbound messages the server has acknowledged.
+type outboxAcks struct {
+	id    func(outbound []byte) string
+	acked func(inbound []byte) (string, bool)
+}
+
+// OpenOutbox opens the outbox at path, creating it if need be. A record left
+// partially written by a crash is discarded.
+func OpenOutbox(path string) (*Outbox, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	msgs, end, err := readOutbox(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return nil, err
+	}
+	o := &Outbox{path: path, file: f}
+	for _, msg := range msgs {
+		o.entries = append(o.entries, &outboxEntry{msg: msg})
+	}
+	return o, nil
+}
+
+// Len returns the number of messages in the outbox.
+func (o *Outbox) Len() (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := 0
+	for _, e := range o.entries {
+		if !e.done {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Close closes the outbox's file.
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.file.Close()
+}
+
+// append durably adds msg to the outbox. It must be called with mu held.
+func (o *Outbox) append(msg wsMessage) (*outboxEntry, error) {
+	if _, err := o.file.Write(outboxRecord(msg)); err != nil {
+		return nil, err
+	}
+	if err := o.file.Sync(); err != nil {
+		return nil, err
+	}
+	e := &outboxEntry{msg: msg}
+	o.entries = append(o.entries, e)
+	return e, nil
+}
+
+// compact drops the entries that are done, rewriting the file without them. The
+// new file replaces the old by rename, so a crash leaves one or the other. It must
+// be called with mu held.
+func (o *Outbox) compact() error {
+	var keep []*outboxEntry
+	for _, e := range o.entries {
+		if !e.done {
+			keep = append(keep, e)
+		}
+	}
+	if len(keep) == len(o.entries) {
+		return nil
+	}
+	if len(keep) == 0 {
+		o.entries = nil
+		return o.file.Truncate(0)
+	}
+
+	tmp, err := os.OpenFile(o.path+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	for _, e := range keep {
+		w.Write(outboxRecord(e.msg))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(o.path+".tmp", o.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(o.path, os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	o.file.Close()
+	o.file = f
+	o.entries = keep
+	return nil
+}
+
+func outboxRecord(msg wsMessage) []byte {
+	rec := make([]byte, outboxHeader+len(msg.bts))
+	rec[0] = byte(msg.typ)
+	binary.BigEndian.PutUint32(rec[1:outboxHeader], uint32(len(msg.bts)))
+	copy(rec[outboxHeader:], msg.bts)
+	return rec
+}
+
+// readOutbox returns the complete records in f, and the offset they end at.
+func readOutbox(f *os.File) ([]wsMessage, int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	bts, err := io.ReadAll(io.NewSectionReader(f, 0, info.Size()))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		msgs []wsMessage
+		end  int64
+	)
+	for len(bts) >= outboxHeader {
+		n := int(binary.BigEndian.Uint32(bts[1:outboxHeader]))
+		if len(bts) < outboxHeader+n {
+			break
+		}
+		msgs = append(msgs, wsMessage{typ: MessageType(bts[0]), bts: bts[outboxHeader : outboxHeader+n]})
+		bts = bts[outboxHeader+n:]
+		end += int64(outboxHeader + n)
+	}
+	return msgs, end, nil
+}
+
+// store appends msg to the client's outbox, if it has one and should: while
+// disconnected, or the outbox is being flushed. It reports whether it did.
+// Messages whose write failed are stored as if disconnected.
+func (c *WSClient) store(msg wsMessage, connected bool) (bool, error) {
+	if c.outbox == nil {
+		return false, nil
+	}
+	o := c.outbox
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if connected && !o.flushing {
+		return false, nil
+	}
+	c.logMessage("outbox", msg.typ, msg.bts)
+	_, err := o.append(msg)
+	return true, err
+}
+
+// track journals msg in the outbox before it is written to the connection, if
+// acknowledgements are tracked and msg has an id, returning its entry.
+func (c *WSClient) track(msg wsMessage) (*outboxEntry, error) {
+	if c.outbox == nil || c.outboxAcks == nil {
+		return nil, nil
+	}
+	id := c.outboxAcks.id(msg.bts)
+	if id == "" {
+		return nil, nil
+	}
+	o := c.outbox
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e, err := o.append(msg)
+	if err != nil {
+		return nil, err
+	}
+	e.id = id
+	return e, nil
+}
+
+// wrote records the outcome of writing a tracked entry: sent, awaiting its ack,
+// or if the write was abandoned rather than failed by the connection, dropped.
+// It reports whether the entry is kept for the next connection.
+func (c *WSClient) wrote(e *outboxEntry, err error, abandoned bool) (bool, error) {
+	o := c.outbox
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch {
+	case err == nil:
+		e.sent = true
+		return false, nil
+	case abandoned:
+		e.done = true
+		return false, o.compact()
+	}
+	return true, nil
+}
+
+// ackOutbox removes the outbox entries msg acknowledges, if any.
+func (c *WSClient) ackOutbox(msg []byte) {
+	if c.outbox == nil || c.outboxAcks == nil {
+		return
+	}
+	id, ok := c.outboxAcks.acked(msg)
+	if !ok {
+		return
+	}
+	o := c.outbox
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, e := range o.entries {
+		if e.id == id {
+			e.done = true
+		}
+	}
+	if err := o.compact(); err != nil {
+		c.logger.Info("failed to compact outbox", "error", err.Error())
+		c.onError(err, false)
+	}
+}
+
+// flushOutbox writes the outbox to the current connection, in order, compacting
+// it as messages leave it. Messages that couldn't be written, or with
+// WithOutboxAcks, that were written to an earlier connection but never
+// acknowledged, are left for the next connection.
+func (c *WSClient) flushOutbox(w *connWriter) error {
+	if c.outbox == nil {
+		return nil
+	}
+	o := c.outbox
+
+	// a new connection needs everything still held
+	o.mu.Lock()
+	for _, e := range o.entries {
+		e.sent = false
+	}
+	o.mu.Unlock()
+
+	for {
+		o.mu.Lock()
+		var pending []*outboxEntry
+		for _, e := range o.entries {
+			if !e.sent && !e.done {
+				pending = append(pending, e)
+			}
+		}
+		if len(pending) == 0 {
+			o.flushing = false
+			err := o.compact()
+			o.mu.Unlock()
+			return err
+		}
+		o.flushing = true
+		o.mu.Unlock()
+		c.logger.Info("flushing outbox", "count", len(pending))
+
+		for _, e := range pending {
+			if err := w.write(nil, e.msg.typ, e.msg.bts); err != nil {
+				o.mu.Lock()
+				o.flushing = false
+				if cerr := o.compact(); cerr != nil {
+					c.logger.Info("failed to compact outbox", "error", cerr.Error())
+				}
+				o.mu.Unlock()
+				return err
+			}
+			o.mu.Lock()
+			e.sent = true
+			if c.outboxAcks == nil {
+				e.done = true
+			} else if e.id == "" {
+				// entries stored while disconnected, or read from the file, are
+				// named as they're written
+				if e.id = c.outboxAcks.id(e.msg.bts); e.id == "" {
+					e.done = true
+				}
+			}
+			o.mu.Unlock()
+		}
+
+		o.mu.Lock()
+		err := o.compact()
+		if err != nil {
+			o.flushing = false
+		}
+		o.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
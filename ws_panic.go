@@ -0,0 +1,36 @@
+package apic
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// PanicError is returned when the handler panics. The connection is terminated,
+// and the error is passed to the reconnect policy.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (pe *PanicError) Error() string {
+	return fmt.Sprintf("handler panic: %v", pe.Value)
+}
+
+// callHandler calls the handler, converting panics in to errors unless
+// recovery has been disabled.
+func (c *WSClient) callHandler(msg wsMessage) (err error) {
+	defer func(start time.Time) {
+		c.metrics.HandlerDuration(c.Endpoint(), c.clock.Now().Sub(start))
+	}(c.clock.Now())
+	if !c.disablePanicRecovery {
+		defer func() {
+			if v := recover(); v != nil {
+				pe := &PanicError{Value: v, Stack: debug.Stack()}
+				c.logger.Info("handler panicked", "panic", fmt.Sprint(v), "stack", string(pe.Stack))
+				err = pe
+			}
+		}()
+	}
+	return c.handler(msg.typ, msg.bts)
+}
@@ -0,0 +1,46 @@
+package apic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// FrameParser normalizes an inbound frame of a non-json wire format, ie, FIX or a
+// delimited ticker, in to a message the rest of the client understands, typically
+// json, so that decoders, typed handlers, and routing work on it unchanged.
+type FrameParser func([]byte) ([]byte, error)
+
+// ParseKeyValue parses frames of key value pairs, ie, FIX's tag=value fields
+// separated by SOH, in to a json object of strings. Where a key repeats, the last
+// value wins.
+func ParseKeyValue(fieldSep, kvSep string) FrameParser {
+	return func(bts []byte) ([]byte, error) {
+		obj := map[string]string{}
+		for _, field := range bytes.Split(bytes.TrimSuffix(bts, []byte(fieldSep)), []byte(fieldSep)) {
+			k, v, ok := bytes.Cut(field, []byte(kvSep))
+			if !ok {
+				return nil, fmt.Errorf("parse frame: field without %q: %q", kvSep, field)
+			}
+			obj[string(k)] = string(v)
+		}
+		return json.Marshal(obj)
+	}
+}
+
+// ParseFields parses frames of positional fields separated by sep, ie, a pipe
+// delimited ticker, in to a json object of strings, keyed by names. Frames with
+// fewer fields than names fail to parse, and fields beyond them are ignored.
+func ParseFields(sep string, names ...string) FrameParser {
+	return func(bts []byte) ([]byte, error) {
+		fields := bytes.Split(bts, []byte(sep))
+		if len(fields) < len(names) {
+			return nil, fmt.Errorf("parse frame: %d fields, want %d", len(fields), len(names))
+		}
+		obj := make(map[string]string, len(names))
+		for i, name := range names {
+			obj[name] = string(fields[i])
+		}
+		return json.Marshal(obj)
+	}
+}
@@ -0,0 +1,99 @@
+package apic
+
+import "sync"
+
+// PausePolicy decides what happens to messages received while the client is
+// paused.
+type PausePolicy int
+
+const (
+	// PauseBuffer holds messages, delivering them in order on Resume. Beyond the
+	// pause buffer size, the oldest held message is dropped to make room.
+	PauseBuffer PausePolicy = iota
+
+	// PauseDrop discards messages.
+	PauseDrop
+)
+
+// pauser holds inbound messages while the client is paused.
+type pauser struct {
+	policy PausePolicy
+	size   int
+
+	mu     sync.Mutex
+	paused bool
+	held   []wsMessage
+
+	// resumed signals the run loop to deliver held messages
+	resumed chan struct{}
+}
+
+func newPauser() *pauser {
+	return &pauser{resumed: make(chan struct{}, 1)}
+}
+
+// Pause stops delivering inbound messages to the handler, without disturbing the
+// connection, until Resume. Messages are held or dropped per the pause policy (see
+// WithPausePolicy); dropped messages count towards DroppedMessages. Call responses,
+// and Messages subscribers, are unaffected.
+func (c *WSClient) Pause() {
+	c.pause.mu.Lock()
+	defer c.pause.mu.Unlock()
+	c.pause.paused = true
+}
+
+// Resume restarts delivering inbound messages to the handler, starting with any
+// held while paused.
+func (c *WSClient) Resume() {
+	c.pause.mu.Lock()
+	defer c.pause.mu.Unlock()
+	c.pause.paused = false
+	select {
+	case c.pause.resumed <- struct{}{}:
+	default:
+	}
+}
+
+// hold reports whether msg was held back from the handler. Messages are held while
+// paused, and after resuming until those held are delivered, to keep them in order.
+func (c *WSClient) hold(msg wsMessage) bool {
+	p := c.pause
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused && len(p.held) == 0 {
+		return false
+	}
+	if p.paused && p.policy == PauseDrop {
+		c.dropped.Add(1)
+		return true
+	}
+	if p.size != 0 && len(p.held) >= p.size {
+		p.held = p.held[1:]
+		c.dropped.Add(1)
+	}
+	p.held = append(p.held, msg)
+	return true
+}
+
+// deliverHeld delivers the messages held while paused, unless paused again.
+func (c *WSClient) deliverHeld(pool *handlerPool) error {
+	for {
+		p := c.pause
+		p.mu.Lock()
+		if p.paused || len(p.held) == 0 {
+			p.mu.Unlock()
+			return nil
+		}
+		msg := p.held[0]
+		p.held = p.held[1:]
+		p.mu.Unlock()
+
+		if pool != nil {
+			pool.dispatch(msg)
+			continue
+		}
+		if err := c.deliver(msg); err != nil {
+			return err
+		}
+	}
+}
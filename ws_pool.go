@@ -0,0 +1,92 @@
+package apic
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+)
+
+// handlerPool runs the handler on a set of worker goroutines. With an ordering
+// key, each worker has its own queue and messages sharing a key always go to the
+// same worker, preserving their order; otherwise workers share a single queue.
+type handlerPool struct {
+	c      *WSClient
+	queues []chan wsMessage
+	errs   chan error
+	wg     sync.WaitGroup
+}
+
+func (c *WSClient) startHandlerPool() *handlerPool {
+	p := &handlerPool{
+		c:    c,
+		errs: make(chan error, c.handlerConcurrency),
+	}
+
+	shared := make(chan wsMessage)
+	for i := 0; i < c.handlerConcurrency; i++ {
+		q := shared
+		if c.orderingKey != nil {
+			q = make(chan wsMessage)
+		}
+		if i == 0 || c.orderingKey != nil {
+			p.queues = append(p.queues, q)
+		}
+
+		p.wg.Add(1)
+		go p.work(q)
+	}
+	return p
+}
+
+func (p *handlerPool) work(q chan wsMessage) {
+	defer p.wg.Done()
+	for msg := range q {
+		if err := p.c.deliver(msg); err != nil {
+			select {
+			case p.errs <- err:
+			default:
+			}
+		}
+	}
+}
+
+// dispatch hands msg to a worker, blocking until one accepts it.
+func (p *handlerPool) dispatch(msg wsMessage) {
+	q := p.queues[0]
+	if len(p.queues) > 1 {
+		h := fnv.New32a()
+		h.Write([]byte(p.c.orderingKey(msg.bts)))
+		q = p.queues[h.Sum32()%uint32(len(p.queues))]
+	}
+	q <- msg
+}
+
+// errors returns the channel handler errors are reported on. It is safe
+// to call on a nil pool, returning a nil channel.
+func (p *handlerPool) errors() chan error {
+	if p == nil {
+		return nil
+	}
+	return p.errs
+}
+
+// stop closes the worker queues, and waits for in flight handlers to return.
+func (p *handlerPool) stop() {
+	for _, q := range p.queues {
+		close(q)
+	}
+	p.wg.Wait()
+}
+
+// OrderByJSONField returns an ordering key func for WithHandlerConcurrency that
+// reads a top level field from json messages, so that messages sharing the field's
+// value are handled in order.
+func OrderByJSONField(field string) func([]byte) string {
+	return func(bts []byte) string {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(bts, &obj); err != nil {
+			return ""
+		}
+		return string(obj[field])
+	}
+}
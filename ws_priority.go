@@ -0,0 +1,175 @@
+package apic
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// Priorities for WriteWithPriority. Higher priorities are written first; any int
+// may be used.
+const (
+	PriorityLow    = -1
+	PriorityNormal = 0
+	PriorityHigh   = 1
+)
+
+type priorityKey struct{}
+
+// withPriority attaches a write priority to ctx.
+func withPriority(ctx context.Context, prio int) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, priorityKey{}, prio)
+}
+
+// priority returns the write priority attached to ctx, or PriorityNormal.
+func priority(ctx context.Context) int {
+	if ctx == nil {
+		return PriorityNormal
+	}
+	if prio, ok := ctx.Value(priorityKey{}).(int); ok {
+		return prio
+	}
+	return PriorityNormal
+}
+
+// WriteWithPriority is Write, with a priority deciding the order messages waiting
+// on the write rate limits are let through in, ie, so that an order cancel isn't
+// stuck behind subscription churn. Messages of equal priority keep their order.
+// Messages above PriorityNormal also skip write batching, to be written straight
+// away. Priority only orders the wait on the rate limits: once let through,
+// messages are written in the order they reach the connection's writer.
+func (c *WSClient) WriteWithPriority(ctx context.Context, obj any, prio int) error {
+	bts, err := c.encoder(obj)
+	if err != nil {
+		return err
+	}
+	ctx = withPriority(ctx, prio)
+	if prio > PriorityNormal {
+		return c.writeFrame(ctx, c.messageType, bts)
+	}
+	return c.Send(ctx, bts)
+}
+
+// writeGate lets writes through a rate limiter in priority order, rather than
+// the order they arrived in.
+type writeGate struct {
+	limiter *rate.Limiter
+
+	mu      sync.Mutex
+	waiters gateWaiters
+	seq     uint64
+	running bool
+}
+
+// newWriteGate creates a gate over l, raising a burst under 1 to 1, since no
+// write could get through it otherwise.
+func newWriteGate(l *rate.Limiter) *writeGate {
+	if l.Burst() < 1 && l.Limit() != rate.Inf {
+		l.SetBurst(1)
+	}
+	return &writeGate{limiter: l}
+}
+
+type gateWaiter struct {
+	prio  int
+	seq   uint64
+	index int
+	ready chan struct{}
+
+	// err is set if the limiter can never let the write through
+	err error
+}
+
+// wait blocks until the write may go ahead, or ctx is done.
+func (g *writeGate) wait(ctx context.Context) error {
+	g.mu.Lock()
+	if len(g.waiters) == 0 && g.limiter.Allow() {
+		g.mu.Unlock()
+		return nil
+	}
+	g.seq++
+	w := &gateWaiter{prio: priority(ctx), seq: g.seq, ready: make(chan struct{})}
+	heap.Push(&g.waiters, w)
+	if !g.running {
+		g.running = true
+		go g.run()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return w.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if w.index < 0 {
+			// let through just as ctx ended; the token is spent either way
+			return w.err
+		}
+		heap.Remove(&g.waiters, w.index)
+		return ctx.Err()
+	}
+}
+
+// run hands out tokens to the most urgent waiter at the time each comes due,
+// until there are none left waiting. If the limiter can't hand out a token at
+// all, ie, with a rate of 0, the waiter is failed with its error.
+func (g *writeGate) run() {
+	for {
+		g.mu.Lock()
+		if len(g.waiters) == 0 {
+			g.running = false
+			g.mu.Unlock()
+			return
+		}
+		g.mu.Unlock()
+
+		err := g.limiter.Wait(context.Background())
+
+		g.mu.Lock()
+		if len(g.waiters) != 0 {
+			w := heap.Pop(&g.waiters).(*gateWaiter)
+			w.err = err
+			close(w.ready)
+		}
+		g.mu.Unlock()
+	}
+}
+
+// gateWaiters is a heap of waiters, most urgent first.
+type gateWaiters []*gateWaiter
+
+func (h gateWaiters) Len() int { return len(h) }
+
+func (h gateWaiters) Less(i, j int) bool {
+	if h[i].prio != h[j].prio {
+		return h[i].prio > h[j].prio
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h gateWaiters) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *gateWaiters) Push(x any) {
+	w := x.(*gateWaiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *gateWaiters) Pop() any {
+	old := *h
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*h = old[:len(old)-1]
+	return w
+}
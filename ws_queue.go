@@ -0,0 +1,105 @@
+package apic
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrQueueFull = errors.New("offline write queue full")
+
+// QueueOverflowPolicy decides what happens when a message is written
+// while disconnected and the offline write queue is full.
+type QueueOverflowPolicy int
+
+const (
+	// QueueReject fails the write with ErrQueueFull.
+	QueueReject QueueOverflowPolicy = iota
+
+	// QueueDropOldest discards the oldest queued message to make room.
+	QueueDropOldest
+
+	// QueueDropNewest silently discards the message being written.
+	QueueDropNewest
+)
+
+// writeQueue holds messages written while disconnected. It is guarded by
+// the client's mu.
+type writeQueue struct {
+	size     int
+	policy   QueueOverflowPolicy
+	messages []wsMessage
+
+	// flushing is set while the queue is being written to a new connection,
+	// so that concurrent writes queue up behind it rather than jumping ahead.
+	flushing bool
+}
+
+func (q *writeQueue) push(msg wsMessage) error {
+	if len(q.messages) < q.size {
+		q.messages = append(q.messages, msg)
+		return nil
+	}
+
+	switch q.policy {
+	case QueueDropOldest:
+		// with no room at all, msg is itself the oldest
+		if len(q.messages) == 0 {
+			return nil
+		}
+		q.messages = append(q.messages[1:], msg)
+		return nil
+	case QueueDropNewest:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// requeue puts msgs, which failed to write, back at the front of the queue,
+// trimming it to size. Writes already accepted can't be rejected, so QueueReject
+// drops the newest, like QueueDropNewest.
+func (q *writeQueue) requeue(msgs []wsMessage) {
+	msgs = append(append([]wsMessage{}, msgs...), q.messages...)
+	if over := len(msgs) - max(q.size, 0); over > 0 {
+		if q.policy == QueueDropOldest {
+			msgs = msgs[over:]
+		} else {
+			msgs = msgs[:len(msgs)-over]
+		}
+	}
+	q.messages = msgs
+}
+
+// flushQueue writes any queued messages to the current connection, in order.
+// Messages that couldn't be written are left queued for the next connection.
+func (c *WSClient) flushQueue(ctx context.Context) error {
+	if c.queue == nil {
+		return nil
+	}
+
+	for {
+		c.mu.Lock()
+		msgs := c.queue.messages
+		c.queue.messages = nil
+		c.queue.flushing = len(msgs) != 0
+		c.metrics.QueueDepth(c.endpointLocked(), 0)
+		w := c.writer
+		c.mu.Unlock()
+
+		if len(msgs) == 0 {
+			return nil
+		}
+		c.logger.Info("flushing offline write queue", "count", len(msgs))
+
+		for i, msg := range msgs {
+			if err := w.write(ctx, msg.typ, msg.bts); err != nil {
+				c.mu.Lock()
+				c.queue.requeue(msgs[i:])
+				c.queue.flushing = false
+				c.metrics.QueueDepth(c.endpointLocked(), len(c.queue.messages))
+				c.mu.Unlock()
+				return err
+			}
+		}
+	}
+}
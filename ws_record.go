@@ -0,0 +1,73 @@
+package apic
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// RecordedFrame is an inbound frame as captured by WithRecorder, one json
+// object per line.
+type RecordedFrame struct {
+	Time time.Time   `json:"time"`
+	Type MessageType `json:"type"`
+	Data []byte      `json:"data"`
+}
+
+// recorder writes inbound frames to w.
+type recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (r *recorder) record(msg wsMessage, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(RecordedFrame{Time: now, Type: msg.typ, Data: msg.bts})
+}
+
+// record captures msg, if recording. Failing to record doesn't fail the connection.
+func (c *WSClient) record(msg wsMessage) {
+	if c.recorder == nil {
+		return
+	}
+	if err := c.recorder.record(msg, c.clock.Now()); err != nil {
+		c.logger.Info("failed to record frame", "error", err.Error())
+		c.onError(err, false)
+	}
+}
+
+// Replay feeds frames recorded by WithRecorder through the client's inbound
+// pipeline and handler, without connecting. speed scales the original pacing
+// between frames: 1 replays in real time, 10 ten times faster, and 0 as fast as
+// the handler allows. Replay returns at the end of r, on the first handler
+// error, or once ctx is done.
+func (c *WSClient) Replay(ctx context.Context, r io.Reader, speed float64) error {
+	receive := c.inboundHandler(nil)
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<30)
+	var last time.Time
+	for sc.Scan() {
+		var f RecordedFrame
+		if err := json.Unmarshal(sc.Bytes(), &f); err != nil {
+			return err
+		}
+		if speed > 0 && !last.IsZero() {
+			if !c.wait(ctx, time.Duration(float64(f.Time.Sub(last))/speed)) {
+				return ctx.Err()
+			}
+		}
+		last = f.Time
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := receive(f.Type, f.Data); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
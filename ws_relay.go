@@ -0,0 +1,81 @@
+package apic
+
+import (
+	"context"
+	"net/http"
+)
+
+// WSRelayConfig configures a WSRelay.
+type WSRelayConfig struct {
+	// Upstream are the options for the upstream client. The relay owns the
+	// upstream's message handler.
+	Upstream []WSOption
+
+	// Downstream are the options for the downstream server. Connections get
+	// a send queue of 256 messages, dropping messages for slow consumers, so
+	// that one stalled connection doesn't hold up the upstream; WithSendQueue
+	// overrides it.
+	Downstream []WSServerOption
+
+	// Filter, if set, decides whether a downstream connection is sent an
+	// upstream message.
+	Filter func(c *WSConn, msg []byte) bool
+}
+
+// relaySendQueue is the default size of a relay connection's send queue.
+const relaySendQueue = 256
+
+// WSRelay maintains a single upstream connection, and fans the messages it
+// receives out to every downstream connection.
+type WSRelay struct {
+	upstream *WSClient
+	server   *WSServer
+	filter   func(*WSConn, []byte) bool
+}
+
+// NewWSRelay creates a relay of the upstream endpoint.
+func NewWSRelay(endpoint string, cfg WSRelayConfig) *WSRelay {
+	r := &WSRelay{
+		server: NewWSServer(append([]WSServerOption{WithSendQueue(relaySendQueue, SlowConsumerDrop)}, cfg.Downstream...)...),
+		filter: cfg.Filter,
+	}
+	opts := append(append([]WSOption{}, cfg.Upstream...), WithWSMessageHandler(r.relay))
+	r.upstream = NewWSClient(endpoint, opts...)
+	return r
+}
+
+// Upstream returns the upstream client, ie, to subscribe with in an onOpen callback.
+func (r *WSRelay) Upstream() *WSClient {
+	return r.upstream
+}
+
+// Server returns the downstream server.
+func (r *WSRelay) Server() *WSServer {
+	return r.server
+}
+
+// Start runs the upstream client. See WSClient.Start.
+func (r *WSRelay) Start(ctx context.Context) error {
+	return r.upstream.Start(ctx)
+}
+
+// ServeHTTP accepts downstream connections. See WSServer.ServeHTTP.
+func (r *WSRelay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.server.ServeHTTP(w, req)
+}
+
+func (r *WSRelay) relay(typ MessageType, bts []byte) error {
+	recipients := 0
+	defer func() { r.server.metrics.Broadcast(r.server.name, recipients) }()
+
+	for _, c := range r.server.Conns() {
+		if r.filter != nil && !r.filter(c, bts) {
+			continue
+		}
+		recipients++
+		if err := c.writeFrame(c.ctx, typ, bts); err != nil {
+			r.server.logger.Debug("relay: send failed", "conn", c.id, "error", err.Error())
+		}
+	}
+	return nil
+}
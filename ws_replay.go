@@ -0,0 +1,48 @@
+package apic
+
+import (
+	"context"
+	"io"
+)
+
+// replaySource is a journal or recording a client replays in place of connecting.
+type replaySource struct {
+	r     io.Reader
+	speed float64
+}
+
+// NewWSReplayClient creates a client that, rather than connecting, replays the
+// frames journaled with WithJournal, or recorded with WithRecorder, from r through
+// its inbound pipeline and handler at the given speed (see Replay), for backtesting
+// code written against a live client. See WithReplaySource.
+func NewWSReplayClient(r io.Reader, speed float64, opts ...WSOption) *WSClient {
+	return NewWSClient("replay", append(append([]WSOption{}, opts...), WithReplaySource(r, speed))...)
+}
+
+// startReplay runs the client over its replay source: it connects, as far as the
+// rest of the client can tell, replays the source, and then disconnects, returning
+// the replay's error, or nil once the source is exhausted or ctx is done.
+func (c *WSClient) startReplay(ctx context.Context, d *drainer) (err error) {
+	defer d.finished()
+	defer c.setState(StateClosed)
+
+	c.logger.Info("replaying")
+	c.setState(StateConnected)
+	if err := c.onOpen(c); err != nil {
+		return err
+	}
+	c.emit(ConnectedEvent{Endpoint: c.Endpoint()})
+	defer func() {
+		if err := c.onClose(c); err != nil {
+			c.logger.Info("onClose returned error", "error", err.Error())
+			c.onError(err, false)
+		}
+		c.emit(DisconnectedEvent{Err: err})
+	}()
+
+	err = c.Replay(ctx, c.replay.r, c.replay.speed)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
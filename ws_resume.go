@@ -0,0 +1,21 @@
+package apic
+
+// sessionResume asks the server to replay what was missed while disconnected.
+type sessionResume struct {
+	token func() string
+	apply func(token, endpoint string, opts *DialOptions) string
+}
+
+// resume applies the resume token, if there is one, to a redial of endpoint.
+// The first dial is never a resume.
+func (c *WSClient) resume(endpoint string, opts *DialOptions) string {
+	if c.sessionResume == nil || !c.dialed {
+		return endpoint
+	}
+	token := c.sessionResume.token()
+	if token == "" {
+		return endpoint
+	}
+	c.logger.Info("resuming session")
+	return c.sessionResume.apply(token, endpoint, opts)
+}
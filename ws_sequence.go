@@ -0,0 +1,29 @@
+package apic
+
+// sequenceTracker detects gaps in inbound sequence numbers. It is only used
+// from the read loop.
+type sequenceTracker struct {
+	extract func([]byte) (uint64, bool)
+	onGap   func(from, to uint64) error
+
+	last uint64
+	seen bool
+}
+
+// check records the sequence number of msg, if it has one, calling onGap with
+// the missing range if any were skipped. A sequence number at or below the last
+// one seen is taken as a duplicate, or the feed restarting, and isn't a gap.
+func (t *sequenceTracker) check(msg []byte) error {
+	seq, ok := t.extract(msg)
+	if !ok {
+		return nil
+	}
+	last, seen := t.last, t.seen
+	if !seen || seq != last {
+		t.last, t.seen = seq, true
+	}
+	if !seen || seq <= last+1 {
+		return nil
+	}
+	return t.onGap(last+1, seq-1)
+}
@@ -0,0 +1,346 @@
+package apic
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+var ErrServerShutdown = errors.New("websocket server shutting down")
+
+// AcceptOptions are the options for accepting websocket connections.
+type AcceptOptions = websocket.AcceptOptions
+
+// WSServer accepts websocket connections, running each on its own goroutine with
+// the server's handler. It is an http.Handler, to be mounted on an http.Server.
+type WSServer struct {
+	logger        Logger
+	encoder       Encoder
+	metrics       WSServerMetrics
+	name          string
+	acceptOptions *AcceptOptions
+
+	// readLimit, if set, replaces the library's default read limit
+	readLimit int64
+
+	// handler is called with each message received, on the connection's goroutine
+	handler func(*WSConn, MessageType, []byte) error
+
+	// onConnect is called once each connection is accepted, and onDisconnect
+	// once it has ended, with the error that ended it
+	onConnect    func(*WSConn) error
+	onDisconnect func(*WSConn, error)
+
+	// acceptAuth, if set, authenticates each handshake
+	acceptAuth func(*http.Request) (any, error)
+
+	// sendQueue, if set, is the size of each connection's send queue, with
+	// slowPolicy applied when one fills up
+	sendQueue  int
+	slowPolicy SlowConsumerPolicy
+
+	// pingInterval and maxIdle, if set, close connections whose peer stops
+	// answering pings within pongTimeout, or stops sending, with onDeadPeer
+	// called with the reason
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+	maxIdle      time.Duration
+	onDeadPeer   func(*WSConn, error)
+
+	// shutdownCode and shutdownReason are sent to connections on Shutdown
+	shutdownCode   StatusCode
+	shutdownReason string
+
+	connSeq atomic.Uint64
+	mu      sync.Mutex
+	conns   map[*WSConn]struct{}
+
+	// shuttingDown stops upgrades once set, and active tracks the in-flight
+	// ServeHTTP calls Shutdown waits for
+	shuttingDown bool
+	active       sync.WaitGroup
+}
+
+// NewWSServer creates a websocket server.
+func NewWSServer(opts ...WSServerOption) *WSServer {
+	s := &WSServer{
+		logger:       noLogger{},
+		metrics:      noMetrics{},
+		encoder:      defaultEncoder,
+		handler:      func(_ *WSConn, _ MessageType, _ []byte) error { return nil },
+		onConnect:    func(_ *WSConn) error { return nil },
+		onDisconnect: func(_ *WSConn, _ error) {},
+		onDeadPeer:   func(_ *WSConn, _ error) {},
+		conns:        map[*WSConn]struct{}{},
+
+		shutdownCode:   websocket.StatusGoingAway,
+		shutdownReason: "server shutting down",
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// WSConn is a connection accepted by a WSServer.
+type WSConn struct {
+	id       uint64
+	server   *WSServer
+	conn     *websocket.Conn
+	req      *http.Request
+	identity any
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	// queue, if the server queues sends, holds messages for the writer
+	queue *sendQueue
+
+	// lastRead is when a message was last received, in unix nanos, and dead
+	// the reason the connection was closed as dead, if it was
+	lastRead atomic.Int64
+	dead     atomic.Pointer[error]
+}
+
+// ID returns the connection's id, unique within its server.
+func (c *WSConn) ID() uint64 {
+	return c.id
+}
+
+// Request returns the http request the connection was upgraded from.
+func (c *WSConn) Request() *http.Request {
+	return c.req
+}
+
+// Identity returns the identity resolved for the connection by the server's
+// accept auth hook, if any. See WithAcceptAuth.
+func (c *WSConn) Identity() any {
+	return c.identity
+}
+
+// Context returns a context that is canceled once the connection has ended.
+func (c *WSConn) Context() context.Context {
+	return c.ctx
+}
+
+// Write encodes and writes an object to the connection.
+func (c *WSConn) Write(ctx context.Context, obj any) error {
+	bts, err := c.server.encoder(obj)
+	if err != nil {
+		return err
+	}
+	return c.Send(ctx, bts)
+}
+
+// Send writes already encoded bytes to the connection as a text message.
+func (c *WSConn) Send(ctx context.Context, bts []byte) error {
+	return c.writeFrame(ctx, MessageText, bts)
+}
+
+// SendBinary writes bytes to the connection as a binary message.
+func (c *WSConn) SendBinary(ctx context.Context, bts []byte) error {
+	return c.writeFrame(ctx, MessageBinary, bts)
+}
+
+func (c *WSConn) writeFrame(ctx context.Context, typ MessageType, bts []byte) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	c.server.logger.Debug("send", "conn", c.id, "type", typ.String(), "size", len(bts))
+	c.server.metrics.MessageSent(c.server.name, len(bts))
+	if c.queue != nil {
+		return c.enqueue(wsMessage{typ: typ, bts: bts})
+	}
+	return c.conn.Write(ctx, typ, bts)
+}
+
+// Close closes the connection with the given status code and reason.
+func (c *WSConn) Close(code StatusCode, reason string) error {
+	return c.conn.Close(code, reason)
+}
+
+// chainOnDisconnect runs fn before the currently configured onDisconnect callback.
+func (s *WSServer) chainOnDisconnect(fn func(*WSConn, error)) {
+	prev := s.onDisconnect
+	s.onDisconnect = func(c *WSConn, err error) {
+		fn(c, err)
+		prev(c, err)
+	}
+}
+
+// Conns returns the server's current connections.
+func (s *WSServer) Conns() []*WSConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns := make([]*WSConn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Broadcast encodes obj once, and sends it to every connection. It returns the
+// errors from connections that couldn't be written to, joined.
+func (s *WSServer) Broadcast(ctx context.Context, obj any) error {
+	bts, err := s.encoder(obj)
+	if err != nil {
+		return err
+	}
+	conns := s.Conns()
+	s.metrics.Broadcast(s.name, len(conns))
+	var errs []error
+	for _, c := range conns {
+		if err := c.Send(ctx, bts); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ServeHTTP upgrades the request to a websocket connection, and runs it until
+// either side closes it, or the handler returns an error.
+func (s *WSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	if s.shuttingDown {
+		s.mu.Unlock()
+		s.metrics.HandshakeRejected(s.name, http.StatusServiceUnavailable)
+		http.Error(w, ErrServerShutdown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	s.active.Add(1)
+	s.mu.Unlock()
+	defer s.active.Done()
+
+	var identity any
+	if s.acceptAuth != nil {
+		var err error
+		if identity, err = s.acceptAuth(r); err != nil {
+			s.reject(w, r, err)
+			return
+		}
+	}
+
+	conn, err := websocket.Accept(w, r, s.acceptOptions)
+	if err != nil {
+		s.logger.Info("accept failed", "error", err.Error())
+		return
+	}
+	if s.readLimit != 0 {
+		conn.SetReadLimit(s.readLimit)
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	c := &WSConn{
+		id:       s.connSeq.Add(1),
+		server:   s,
+		conn:     conn,
+		req:      r,
+		identity: identity,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	c.startWriter()
+	c.startKeepalive()
+
+	s.mu.Lock()
+	s.conns[c] = struct{}{}
+	s.metrics.Connections(s.name, len(s.conns))
+	s.mu.Unlock()
+	s.logger.Info("connection accepted", "conn", c.id, "remote", r.RemoteAddr)
+
+	err = s.serve(c)
+	if reason := c.dead.Load(); reason != nil {
+		err = *reason
+	}
+
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.metrics.Connections(s.name, len(s.conns))
+	s.mu.Unlock()
+	cancel()
+
+	s.logger.Info("connection ended", "conn", c.id, "error", err)
+	s.onDisconnect(c, err)
+}
+
+// Shutdown gracefully shuts down the server, like http.Server.Shutdown: new
+// upgrades are refused with a 503, every connection is sent a close frame with the
+// server's shutdown status (see WithShutdownStatus), and Shutdown waits for their
+// handlers to return. If ctx ends first, the remaining connections are closed
+// without a handshake, and ctx's error is returned.
+func (s *WSServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shuttingDown = true
+	s.mu.Unlock()
+
+	conns := s.Conns()
+	s.logger.Info("shutting down", "conns", len(conns))
+	for _, c := range conns {
+		go c.Close(s.shutdownCode, s.shutdownReason)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		for _, c := range s.Conns() {
+			c.conn.CloseNow()
+		}
+		return ctx.Err()
+	}
+}
+
+// reject fails the handshake for a request the accept auth hook refused.
+func (s *WSServer) reject(w http.ResponseWriter, r *http.Request, err error) {
+	status, msg := http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized)
+	var ae *AcceptError
+	if errors.As(err, &ae) {
+		status = ae.Status
+		if ae.Message != "" {
+			msg = ae.Message
+		} else {
+			msg = http.StatusText(ae.Status)
+		}
+	}
+	s.logger.Info("handshake rejected", "remote", r.RemoteAddr, "status", status, "error", err.Error())
+	s.metrics.HandshakeRejected(s.name, status)
+	http.Error(w, msg, status)
+}
+
+// serve runs the connection, returning the error that ended it.
+func (s *WSServer) serve(c *WSConn) error {
+	if err := s.onConnect(c); err != nil {
+		c.conn.Close(websocket.StatusPolicyViolation, "connection refused")
+		return err
+	}
+
+	for {
+		typ, bts, err := c.conn.Read(c.ctx)
+		if err != nil {
+			if websocket.CloseStatus(err) == websocket.StatusNormalClosure {
+				return nil
+			}
+			return err
+		}
+		c.lastRead.Store(time.Now().UnixNano())
+		s.logger.Debug("recv", "conn", c.id, "type", typ.String(), "size", len(bts))
+		s.metrics.MessageReceived(s.name, len(bts))
+		if err := s.handler(c, typ, bts); err != nil {
+			c.conn.Close(websocket.StatusInternalError, "handler error")
+			return err
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package apic
+
+import (
+	"errors"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+var (
+	ErrPongTimeout = errors.New("websocket peer did not answer ping")
+	ErrIdleTimeout = errors.New("websocket peer idle too long")
+)
+
+// keepalive pings the peer every pingInterval, and watches for it going quiet
+// for longer than maxIdle, closing the connection as dead on either.
+func (c *WSConn) keepalive() {
+	s := c.server
+
+	var ping <-chan time.Time
+	if s.pingInterval > 0 {
+		t := time.NewTicker(s.pingInterval)
+		defer t.Stop()
+		ping = t.C
+	}
+
+	var idle <-chan time.Time
+	var idleTimer *time.Timer
+	if s.maxIdle > 0 {
+		idleTimer = time.NewTimer(s.maxIdle)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ping:
+			if c.pong(s.pongTimeout) {
+				continue
+			}
+			if c.ctx.Err() == nil {
+				// the peer is gone; there's nobody to handshake the close with
+				c.markDead(ErrPongTimeout)
+				c.conn.CloseNow()
+			}
+			return
+		case <-idle:
+			if left := s.maxIdle - time.Since(time.Unix(0, c.lastRead.Load())); left > 0 {
+				idleTimer.Reset(left)
+				continue
+			}
+			c.markDead(ErrIdleTimeout)
+			c.conn.Close(websocket.StatusPolicyViolation, "idle timeout")
+			return
+		}
+	}
+}
+
+// pong pings the peer, reporting whether it answered within timeout. The ping
+// isn't bounded by a context deadline, which would have the websocket close the
+// connection before it could be marked dead.
+func (c *WSConn) pong(timeout time.Duration) bool {
+	done := make(chan error, 1)
+	go func() { done <- c.conn.Ping(c.ctx) }()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case err := <-done:
+		return err == nil
+	case <-t.C:
+		return false
+	}
+}
+
+// markDead records why the connection is being closed as dead, and reports it.
+func (c *WSConn) markDead(reason error) {
+	c.dead.Store(&reason)
+	c.server.logger.Info("closing dead peer", "conn", c.id, "reason", reason.Error())
+	c.server.onDeadPeer(c, reason)
+}
+
+// startKeepalive starts c's keepalive, if the server pings or times out idle
+// connections.
+func (c *WSConn) startKeepalive() {
+	c.lastRead.Store(time.Now().UnixNano())
+	if c.server.pingInterval == 0 && c.server.maxIdle == 0 {
+		return
+	}
+	go c.keepalive()
+}
@@ -0,0 +1,156 @@
+package apic
+
+import (
+	"net/http"
+	"time"
+)
+
+type WSServerOption func(*WSServer)
+
+// WithWSServerLogger sets the logger for the websocket server.
+func WithWSServerLogger(l Logger) WSServerOption {
+	return func(s *WSServer) {
+		s.logger = l
+	}
+}
+
+// WithWSServerMetrics sets the metrics the server reports to, labeled by name. See
+// WSMetrics, and WSServerMetrics for the server only instrumentation.
+func WithWSServerMetrics(name string, m WSMetrics) WSServerOption {
+	return func(s *WSServer) {
+		s.name = name
+		s.metrics = serverMetrics(m)
+	}
+}
+
+// WithWSServerEncoder sets the encoder for objects written with WSConn.Write
+// and Broadcast.
+func WithWSServerEncoder(fn func(any) ([]byte, error)) WSServerOption {
+	return func(s *WSServer) {
+		s.encoder = fn
+	}
+}
+
+// WithAcceptOptions sets the options for accepting connections, ie, the allowed
+// origins and subprotocols.
+func WithAcceptOptions(opts *AcceptOptions) WSServerOption {
+	return func(s *WSServer) {
+		s.acceptOptions = opts
+	}
+}
+
+// WithWSServerHandler sets the handler called with each message received on any
+// connection. Messages on a connection are handled one at a time, in order. A
+// handler error closes the connection.
+func WithWSServerHandler(fn func(c *WSConn, bts []byte) error) WSServerOption {
+	return func(s *WSServer) {
+		s.handler = func(c *WSConn, _ MessageType, bts []byte) error { return fn(c, bts) }
+	}
+}
+
+// WithWSServerMessageHandler is WithWSServerHandler, passing along whether each
+// message was a text or binary frame.
+func WithWSServerMessageHandler(fn func(c *WSConn, typ MessageType, bts []byte) error) WSServerOption {
+	return func(s *WSServer) {
+		s.handler = fn
+	}
+}
+
+// WithWSServerReplyHandler is WithWSServerHandler, writing any non-nil bytes fn
+// returns back to the connection, as the same frame type as the message being
+// replied to. A failed reply is treated like a handler error.
+func WithWSServerReplyHandler(fn func(c *WSConn, bts []byte) ([]byte, error)) WSServerOption {
+	return func(s *WSServer) {
+		s.handler = func(c *WSConn, typ MessageType, bts []byte) error {
+			reply, err := fn(c, bts)
+			if err != nil || reply == nil {
+				return err
+			}
+			return c.writeFrame(c.ctx, typ, reply)
+		}
+	}
+}
+
+// WithSendQueue gives each connection a send queue of size messages, drained by a
+// writer goroutine, so that sends (including broadcasts) never block on a slow
+// client. When a connection's queue is full, policy decides whether the message is
+// dropped or the connection closed; either way, the send returns ErrSlowConsumer.
+func WithSendQueue(size int, policy SlowConsumerPolicy) WSServerOption {
+	return func(s *WSServer) {
+		s.sendQueue = size
+		s.slowPolicy = policy
+	}
+}
+
+// WithWSServerReadLimit caps the size of each message read from a connection at n
+// bytes; a larger one closes the connection with StatusMessageTooBig. The default
+// is the websocket library's 32KiB, and n < 0 removes the limit.
+func WithWSServerReadLimit(n int64) WSServerOption {
+	return func(s *WSServer) {
+		s.readLimit = n
+	}
+}
+
+// WithServerPing pings each connection every interval, closing it if the peer
+// doesn't answer within timeout (which defaults to interval). The reason is
+// reported to the WithDeadPeerHandler callback, and to onDisconnect.
+func WithServerPing(interval, timeout time.Duration) WSServerOption {
+	return func(s *WSServer) {
+		if timeout == 0 {
+			timeout = interval
+		}
+		s.pingInterval = interval
+		s.pongTimeout = timeout
+	}
+}
+
+// WithMaxIdle closes connections that haven't sent a message in d. Pongs don't
+// count as activity.
+func WithMaxIdle(d time.Duration) WSServerOption {
+	return func(s *WSServer) {
+		s.maxIdle = d
+	}
+}
+
+// WithDeadPeerHandler sets the callback invoked as a connection is closed for
+// missing a pong (ErrPongTimeout), or being idle (ErrIdleTimeout).
+func WithDeadPeerHandler(fn func(c *WSConn, reason error)) WSServerOption {
+	return func(s *WSServer) {
+		s.onDeadPeer = fn
+	}
+}
+
+// WithShutdownStatus sets the close status and reason sent to every connection on
+// Shutdown. The default is StatusGoingAway.
+func WithShutdownStatus(code StatusCode, reason string) WSServerOption {
+	return func(s *WSServer) {
+		s.shutdownCode = code
+		s.shutdownReason = reason
+	}
+}
+
+// WithAcceptAuth authenticates each handshake with fn before it is accepted. The
+// identity fn returns is attached to the connection, and available from
+// WSConn.Identity. If fn returns an error, the handshake is rejected with a 401, or
+// the status of an *AcceptError.
+func WithAcceptAuth(fn func(r *http.Request) (identity any, err error)) WSServerOption {
+	return func(s *WSServer) {
+		s.acceptAuth = fn
+	}
+}
+
+// WithWSServerOnConnect sets the callback invoked as each connection is accepted,
+// before any of its messages are handled. An error closes the connection.
+func WithWSServerOnConnect(fn func(*WSConn) error) WSServerOption {
+	return func(s *WSServer) {
+		s.onConnect = fn
+	}
+}
+
+// WithWSServerOnDisconnect sets the callback invoked once each connection has
+// ended, with the error that ended it, if any.
+func WithWSServerOnDisconnect(fn func(*WSConn, error)) WSServerOption {
+	return func(s *WSServer) {
+		s.onDisconnect = fn
+	}
+}
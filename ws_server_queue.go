@@ -0,0 +1,74 @@
+package apic
+
+import (
+	"errors"
+
+	"nhooyr.io/websocket"
+)
+
+var ErrSlowConsumer = errors.New("websocket connection send queue full")
+
+// SlowConsumerPolicy decides what happens when a message is sent to a server
+// connection whose send queue is full.
+type SlowConsumerPolicy int
+
+const (
+	// SlowConsumerDrop discards the message.
+	SlowConsumerDrop SlowConsumerPolicy = iota
+
+	// SlowConsumerDisconnect closes the connection.
+	SlowConsumerDisconnect
+)
+
+// sendQueue is a connection's bounded outbound queue, drained by its writer.
+type sendQueue struct {
+	policy SlowConsumerPolicy
+	msgs   chan wsMessage
+}
+
+// enqueue queues msg for c's writer, applying the slow consumer policy if the
+// queue is full.
+func (c *WSConn) enqueue(msg wsMessage) error {
+	select {
+	case c.queue.msgs <- msg:
+		return nil
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	default:
+	}
+	c.server.metrics.SendQueueOverflow(c.server.name)
+
+	if c.queue.policy == SlowConsumerDisconnect {
+		c.server.logger.Info("disconnecting slow consumer", "conn", c.id)
+		// closing waits on the peer, which mustn't hold up the sender
+		go c.conn.Close(websocket.StatusPolicyViolation, "slow consumer")
+		return ErrSlowConsumer
+	}
+	c.server.logger.Debug("dropping message for slow consumer", "conn", c.id)
+	return ErrSlowConsumer
+}
+
+// writer writes queued messages until the connection ends.
+func (c *WSConn) writer() {
+	for {
+		select {
+		case msg := <-c.queue.msgs:
+			if err := c.conn.Write(c.ctx, msg.typ, msg.bts); err != nil {
+				c.server.logger.Debug("send failed", "conn", c.id, "error", err.Error())
+				c.cancel()
+				return
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// startWriter starts c's writer, if the server queues sends.
+func (c *WSConn) startWriter() {
+	if c.server.sendQueue == 0 {
+		return
+	}
+	c.queue = &sendQueue{policy: c.server.slowPolicy, msgs: make(chan wsMessage, c.server.sendQueue)}
+	go c.writer()
+}
@@ -0,0 +1,47 @@
+package apic
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// splitMiddleware hands each logical message split out of a frame to the rest of
+// the inbound pipeline in turn.
+func splitMiddleware(split func([]byte) [][]byte) InboundMiddleware {
+	return func(next InboundFunc) InboundFunc {
+		return func(typ MessageType, bts []byte) error {
+			for _, msg := range split(bts) {
+				if err := next(typ, msg); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// SplitLines is a frame splitter for newline delimited messages, the inverse of
+// BatchNewline. Blank lines are skipped.
+func SplitLines(bts []byte) [][]byte {
+	var msgs [][]byte
+	for _, line := range bytes.Split(bts, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) != 0 {
+			msgs = append(msgs, line)
+		}
+	}
+	return msgs
+}
+
+// SplitJSONArray is a frame splitter for messages batched as a json array, the
+// inverse of BatchArray. Frames that aren't an array are passed through whole.
+func SplitJSONArray(bts []byte) [][]byte {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(bts, &raw); err != nil {
+		return [][]byte{bts}
+	}
+	msgs := make([][]byte, len(raw))
+	for i, msg := range raw {
+		msgs[i] = msg
+	}
+	return msgs
+}
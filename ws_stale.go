@@ -0,0 +1,52 @@
+package apic
+
+import "time"
+
+// staleClasses gives classes of inbound messages stale timeouts of their own.
+type staleClasses struct {
+	classify func([]byte) string
+	timeouts map[string]time.Duration
+}
+
+// classLiveness tracks when each class with a stale timeout was last received
+// on a connection.
+type classLiveness struct {
+	classes     *staleClasses
+	connectedAt time.Time
+	seen        map[string]time.Time
+}
+
+func (c *WSClient) newClassLiveness(connectedAt time.Time) *classLiveness {
+	return &classLiveness{classes: c.staleClasses, connectedAt: connectedAt, seen: map[string]time.Time{}}
+}
+
+// received notes msg's arrival at now. A nil classLiveness does nothing.
+func (l *classLiveness) received(msg []byte, now time.Time) {
+	if l == nil || l.classes == nil {
+		return
+	}
+	class := l.classes.classify(msg)
+	if _, ok := l.classes.timeouts[class]; ok {
+		l.seen[class] = now
+	}
+}
+
+// stale returns a class that has gone without a message for longer than its
+// timeout as of now, and when it was last received, which is zero if never.
+// Classes not yet received are timed from the connection opening.
+func (l *classLiveness) stale(now time.Time) (string, time.Time, bool) {
+	if l == nil || l.classes == nil {
+		return "", time.Time{}, false
+	}
+	for class, timeout := range l.classes.timeouts {
+		last, ok := l.seen[class]
+		since := last
+		if !ok {
+			since = l.connectedAt
+		}
+		if now.Sub(since) > timeout {
+			return class, last, true
+		}
+	}
+	return "", time.Time{}, false
+}
@@ -0,0 +1,105 @@
+package apic
+
+// ConnState is the state of a WSClient's connection.
+type ConnState int
+
+const (
+	// StateDisconnected is the state before Start, and between connections.
+	StateDisconnected ConnState = iota
+
+	// StateConnecting is the state while making the first connection attempt.
+	StateConnecting
+
+	// StateConnected is the state once connected, and the onOpen callback has returned.
+	StateConnected
+
+	// StateReconnecting is the state while making a reconnect attempt.
+	StateReconnecting
+
+	// StateClosed is the state once Start has returned.
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// stateBuffer is how many state changes a subscriber may fall behind by
+// before further changes are dropped for it.
+const stateBuffer = 16
+
+// Status returns the client's current connection state.
+func (c *WSClient) Status() ConnState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// StateChanges subscribes to connection state changes. Changes are dropped for
+// subscribers that fall too far behind. The returned func unsubscribes, and closes
+// the channel.
+func (c *WSClient) StateChanges() (<-chan ConnState, func()) {
+	ch := make(chan ConnState, stateBuffer)
+
+	c.mu.Lock()
+	if c.stateSubs == nil {
+		c.stateSubs = map[chan ConnState]struct{}{}
+	}
+	c.stateSubs[ch] = struct{}{}
+	c.mu.Unlock()
+
+	return ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, ok := c.stateSubs[ch]; ok {
+			delete(c.stateSubs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (c *WSClient) setState(s ConnState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setStateLocked(s)
+}
+
+// setStateLocked must be called with mu held.
+func (c *WSClient) setStateLocked(s ConnState) {
+	if c.state == s {
+		return
+	}
+	c.logger.Debug("state change", "from", c.state.String(), "to", s.String())
+	c.state = s
+	c.metrics.State(c.endpointLocked(), s)
+
+	if s == StateConnected {
+		close(c.ready)
+	} else {
+		select {
+		case <-c.ready:
+			c.ready = make(chan struct{})
+		default:
+		}
+	}
+
+	for ch := range c.stateSubs {
+		select {
+		case ch <- s:
+		default:
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package apic
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WSStats is a snapshot of a WSClient's counters.
+type WSStats struct {
+	// Connects counts successful connections, and Reconnects reconnect attempts.
+	Connects   uint64
+	Reconnects uint64
+
+	// LastConnect is when the latest connection was made.
+	LastConnect time.Time
+
+	MessagesIn  uint64
+	MessagesOut uint64
+	BytesIn     uint64
+	BytesOut    uint64
+
+	// LastMessage is when the latest message was received.
+	LastMessage time.Time
+
+	// Attempt is the current reconnect attempt, zero once a connection is stable.
+	Attempt int
+}
+
+// wsStats holds the counters behind WSStats. Times are unix nanoseconds.
+type wsStats struct {
+	connects    atomic.Uint64
+	reconnects  atomic.Uint64
+	lastConnect atomic.Int64
+	messagesIn  atomic.Uint64
+	messagesOut atomic.Uint64
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+	lastMessage atomic.Int64
+	attempt     atomic.Int64
+}
+
+// Stats returns a snapshot of the client's counters.
+func (c *WSClient) Stats() WSStats {
+	s := &c.stats
+	return WSStats{
+		Connects:    s.connects.Load(),
+		Reconnects:  s.reconnects.Load(),
+		LastConnect: unixNanoTime(s.lastConnect.Load()),
+		MessagesIn:  s.messagesIn.Load(),
+		MessagesOut: s.messagesOut.Load(),
+		BytesIn:     s.bytesIn.Load(),
+		BytesOut:    s.bytesOut.Load(),
+		LastMessage: unixNanoTime(s.lastMessage.Load()),
+		Attempt:     int(s.attempt.Load()),
+	}
+}
+
+func (s *wsStats) received(n int, now time.Time) {
+	s.messagesIn.Add(1)
+	s.bytesIn.Add(uint64(n))
+	s.lastMessage.Store(now.UnixNano())
+}
+
+func (s *wsStats) sent(n int) {
+	s.messagesOut.Add(1)
+	s.bytesOut.Add(uint64(n))
+}
+
+func (s *wsStats) connected(now time.Time) {
+	s.connects.Add(1)
+	s.lastConnect.Store(now.UnixNano())
+}
+
+func unixNanoTime(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
@@ -0,0 +1,89 @@
+package apic
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// TokenSource fetches an auth token, and when it expires. A zero expiry means
+// the expiry is unknown, and a new token is fetched for every connection.
+type TokenSource func(ctx context.Context) (token string, expiry time.Time, err error)
+
+// TokenPlacement is where a token is put when dialing. See TokenHeader and TokenQuery.
+type TokenPlacement struct {
+	header string
+	scheme string
+	query  string
+}
+
+// TokenHeader places the token in the named request header, prefixed by scheme
+// (ie, "Bearer") if it is set.
+func TokenHeader(name, scheme string) TokenPlacement {
+	return TokenPlacement{header: name, scheme: scheme}
+}
+
+// TokenQuery places the token in the named endpoint query parameter.
+func TokenQuery(param string) TokenPlacement {
+	return TokenPlacement{query: param}
+}
+
+// wsToken caches the token from a TokenSource between connections.
+type wsToken struct {
+	source    TokenSource
+	placement TokenPlacement
+	refresh   time.Duration
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// get returns the cached token, unless it expires within the refresh window,
+// in which case a new one is fetched.
+func (t *wsToken) get(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.token != "" && !t.expiry.IsZero() && time.Until(t.expiry) > t.refresh {
+		return t.token, nil
+	}
+	token, expiry, err := t.source(ctx)
+	if err != nil {
+		return "", err
+	}
+	t.token, t.expiry = token, expiry
+	return token, nil
+}
+
+// authorize applies the token, if there is a token source, to the endpoint or
+// the dial options' headers.
+func (c *WSClient) authorize(ctx context.Context, endpoint string, opts *DialOptions) (string, error) {
+	if c.token == nil {
+		return endpoint, nil
+	}
+	token, err := c.token.get(ctx)
+	if err != nil {
+		return "", fmt.Errorf("token: %w", err)
+	}
+
+	p := c.token.placement
+	if p.query != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return "", err
+		}
+		q := u.Query()
+		q.Set(p.query, token)
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+
+	if p.scheme != "" {
+		token = p.scheme + " " + token
+	}
+	opts.HTTPHeader.Set(p.header, token)
+	return endpoint, nil
+}
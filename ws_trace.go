@@ -0,0 +1,63 @@
+package apic
+
+import (
+	"context"
+	"math/rand"
+
+	"nhooyr.io/websocket"
+)
+
+// WSTracer starts spans for a WSClient. It is shaped after the OpenTelemetry
+// trace.Tracer, so a thin wrapper around one satisfies it, and spans nest under
+// whatever span is in the context passed to Start, Write, and Send.
+type WSTracer interface {
+	Start(ctx context.Context, name string, attrs ...WSAttr) (context.Context, WSSpan)
+}
+
+// WSSpan is a span started by a WSTracer.
+type WSSpan interface {
+	SetAttributes(attrs ...WSAttr)
+	RecordError(err error)
+	End()
+}
+
+// WSAttr is a span attribute.
+type WSAttr struct {
+	Key   string
+	Value any
+}
+
+type noSpan struct{}
+
+func (noSpan) SetAttributes(_ ...WSAttr) {}
+func (noSpan) RecordError(_ error)       {}
+func (noSpan) End()                      {}
+
+// startSpan starts a span tagged with the endpoint, if tracing is enabled.
+func (c *WSClient) startSpan(ctx context.Context, name string, attrs ...WSAttr) (context.Context, WSSpan) {
+	if c.tracer == nil {
+		return ctx, noSpan{}
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return c.tracer.Start(ctx, name, append([]WSAttr{{Key: "ws.endpoint", Value: c.Endpoint()}}, attrs...)...)
+}
+
+// sampleMessage reports whether a message should get its own span.
+func (c *WSClient) sampleMessage() bool {
+	return c.tracer != nil && c.traceSampleRate > 0 && rand.Float64() < c.traceSampleRate
+}
+
+// endSpan records err, if any, and ends span.
+func endSpan(span WSSpan, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// closeCodeAttr is the close code attribute for the error that ended a connection.
+func closeCodeAttr(err error) WSAttr {
+	return WSAttr{Key: "ws.close_code", Value: int(websocket.CloseStatus(err))}
+}
@@ -0,0 +1,109 @@
+package apic
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+)
+
+// maxDecompressedSize bounds what Gunzip and Inflate will decompress a payload
+// to, so a small hostile payload can't expand without limit.
+const maxDecompressedSize = 64 << 20
+
+var ErrDecompressedTooLarge = errors.New("decompressed payload too large")
+
+// PayloadTransform transforms a message payload, ie, compressing it.
+type PayloadTransform func([]byte) ([]byte, error)
+
+// transformMiddleware applies outbound and inbound payload transforms, either
+// of which may be nil.
+func transformMiddleware(outbound, inbound PayloadTransform) (InboundMiddleware, OutboundMiddleware) {
+	var in InboundMiddleware
+	if inbound != nil {
+		in = func(next InboundFunc) InboundFunc {
+			return func(typ MessageType, bts []byte) error {
+				bts, err := inbound(bts)
+				if err != nil {
+					return err
+				}
+				return next(typ, bts)
+			}
+		}
+	}
+
+	var out OutboundMiddleware
+	if outbound != nil {
+		out = func(next OutboundFunc) OutboundFunc {
+			return func(ctx context.Context, typ MessageType, bts []byte) error {
+				bts, err := outbound(bts)
+				if err != nil {
+					return err
+				}
+				return next(ctx, typ, bts)
+			}
+		}
+	}
+	return in, out
+}
+
+// Gzip compresses a payload with gzip.
+func Gzip(bts []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(bts); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Gunzip decompresses a gzip payload, of at most 64MiB decompressed.
+func Gunzip(bts []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(bts))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return readDecompressed(zr)
+}
+
+// Deflate compresses a payload with raw deflate.
+func Deflate(bts []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(bts); err != nil {
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Inflate decompresses a raw deflate payload, of at most 64MiB decompressed.
+func Inflate(bts []byte) ([]byte, error) {
+	fr := flate.NewReader(bytes.NewReader(bts))
+	defer fr.Close()
+	return readDecompressed(fr)
+}
+
+// readDecompressed reads r to the end, failing with ErrDecompressedTooLarge past
+// maxDecompressedSize.
+func readDecompressed(r io.Reader) ([]byte, error) {
+	bts, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(bts) > maxDecompressedSize {
+		return nil, ErrDecompressedTooLarge
+	}
+	return bts, nil
+}
@@ -0,0 +1,57 @@
+package apic
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// transportConfig customizes the http transport used for the handshake.
+type transportConfig struct {
+	proxy  string
+	dialer *net.Dialer
+	tls    *tls.Config
+}
+
+// client returns an http client for the handshake, based on base if it has one.
+func (t *transportConfig) client(base *http.Client) (*http.Client, error) {
+	var tr *http.Transport
+	if base != nil {
+		if btr, ok := base.Transport.(*http.Transport); ok {
+			tr = btr.Clone()
+		}
+	}
+	if tr == nil {
+		tr = http.DefaultTransport.(*http.Transport).Clone()
+	}
+
+	if t.proxy != "" {
+		u, err := url.Parse(t.proxy)
+		if err != nil {
+			return nil, err
+		}
+		tr.Proxy = http.ProxyURL(u)
+	}
+	if t.dialer != nil {
+		tr.DialContext = t.dialer.DialContext
+	}
+	if t.tls != nil {
+		tr.TLSClientConfig = t.tls.Clone()
+	}
+
+	c := &http.Client{Transport: tr}
+	if base != nil {
+		c.Jar = base.Jar
+		c.CheckRedirect = base.CheckRedirect
+	}
+	return c, nil
+}
+
+// transportConfig returns the client's transport config, creating it if need be.
+func (c *WSClient) transportConfig() *transportConfig {
+	if c.transport == nil {
+		c.transport = &transportConfig{}
+	}
+	return c.transport
+}
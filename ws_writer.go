@@ -0,0 +1,78 @@
+package apic
+
+import (
+	"context"
+
+	"nhooyr.io/websocket"
+)
+
+// writeReq is a message for a connection's writer, and where to report the
+// result of writing it.
+type writeReq struct {
+	ctx  context.Context
+	typ  MessageType
+	bts  []byte
+	done chan error
+}
+
+// connWriter is the single goroutine writing messages to a connection, so that
+// concurrent Writes never interleave, and each has the write timeout applied to
+// its own write alone. Pings and the close handshake are control frames, which the
+// websocket library serializes with data frames itself.
+type connWriter struct {
+	conn *websocket.Conn
+	reqs chan writeReq
+
+	// quit is closed once the connection is done with, stopping the writer
+	quit chan struct{}
+}
+
+// startWriter starts the writer for conn.
+func (c *WSClient) startWriter(conn *websocket.Conn) *connWriter {
+	w := &connWriter{
+		conn: conn,
+		reqs: make(chan writeReq),
+		quit: make(chan struct{}),
+	}
+	go c.runWriter(w)
+	return w
+}
+
+// runWriter writes each message submitted to w, in order, until w is stopped.
+func (c *WSClient) runWriter(w *connWriter) {
+	for {
+		select {
+		case req := <-w.reqs:
+			if err := req.ctx.Err(); err != nil {
+				req.done <- err
+				continue
+			}
+			req.done <- c.writeConn(req.ctx, w.conn, req.typ, req.bts)
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+// write submits a message to the writer, and waits for it to be written.
+func (w *connWriter) write(ctx context.Context, typ MessageType, bts []byte) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req := writeReq{ctx: ctx, typ: typ, bts: bts, done: make(chan error, 1)}
+	select {
+	case w.reqs <- req:
+	case <-w.quit:
+		return ErrNotConnected
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// once submitted, the write has the connection; abandoning it part way
+	// would corrupt the stream, so it is waited for even if ctx ends
+	return <-req.done
+}
+
+// stop stops the writer. Writes waiting to be submitted fail with ErrNotConnected.
+func (w *connWriter) stop() {
+	close(w.quit)
+}